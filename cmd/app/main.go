@@ -2,15 +2,19 @@ package main
 
 import (
 	"context"
+	"expvar"
 	"html/template"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/CodenSell/WB_test_level0/internal/api"
-	"github.com/CodenSell/WB_test_level0/internal/cache"
 	"github.com/CodenSell/WB_test_level0/internal/broker"
+	"github.com/CodenSell/WB_test_level0/internal/cache"
 	"github.com/CodenSell/WB_test_level0/internal/storage/postgres"
 )
 
@@ -19,34 +23,207 @@ func main() {
 		os.Getenv("POSTGRES_USER"),
 		os.Getenv("POSTGRES_PASSWORD"),
 		os.Getenv("POSTGRES_DB"),
-		"postgres", 5432,
+		envOr("POSTGRES_HOST", "postgres"), envInt("POSTGRES_PORT", 5432),
 	)
 	if err != nil {
 		log.Fatal("cant connect to DB:", err)
 	}
 	log.Println("DB connection true")
 
-	cache := cache.NewCache(repo, "data/model.json")
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(repo, os.Args[2:])
+		return
+	}
+	if envBool("MIGRATE_ON_START", false) {
+		applied, err := repo.MigrateUp(context.Background())
+		if err != nil {
+			log.Fatal("cant migrate DB:", err)
+		}
+		log.Printf("migrations applied: %d", len(applied))
+	}
+
+	// в общем Redis запись уже видна всем экземплярам, а событие об изменении
+	// заставило бы остальных удалить из него только что записанный заказ,
+	// поэтому рассылка нужна только локальным кэшам
+	backend := envOr("CACHE_BACKEND", "memory")
+	var invalidator *consumer.Invalidator
+	var onChange func(uid string)
+	if topic := envOr("KAFKA_INVALIDATION_TOPIC", "orders.invalidations"); topic != "-" && backend == "memory" {
+		instance := os.Getenv("INSTANCE_ID")
+		if instance == "" {
+			instance, _ = os.Hostname()
+		}
+		invalidator = consumer.NewInvalidator(consumer.InvalidationConfig{
+			Brokers:  []string{os.Getenv("KAFKA_URL")},
+			Topic:    topic,
+			Instance: instance,
+			Refresh:  envBool("CACHE_INVALIDATION_REFRESH", false),
+		})
+		defer invalidator.Close()
+		onChange = invalidator.Publish
+	}
+
+	// memory — встроенный кэш; прогрев и снимки есть только у него
+	var orders cache.OrderCache
+	var memory *cache.Cache
+	switch backend {
+	case "memory":
+		memory = cache.NewCache(repo, "data/model.json", cache.Options{
+			MaxEntries:   envInt("CACHE_MAX_ENTRIES", 100000),
+			MaxBytes:     int64(envInt("CACHE_MAX_BYTES", 256<<20)),
+			TTL:          envDuration("CACHE_TTL", 10*time.Minute),
+			RefreshAhead: envDuration("CACHE_REFRESH_AHEAD", time.Minute),
+
+			NegativeTTL:        envDuration("CACHE_NEGATIVE_TTL", 30*time.Second),
+			NegativeMaxEntries: envInt("CACHE_NEGATIVE_MAX_ENTRIES", 10000),
+
+			IndexMaxEntries: envInt("CACHE_INDEX_MAX_ENTRIES", 10000),
+
+			LoadTimeout: envDuration("CACHE_LOAD_TIMEOUT", 5*time.Second),
+
+			Shards: envInt("CACHE_SHARDS", 16),
+
+			WarmWorkers:  envInt("CACHE_WARM_WORKERS", 4),
+			WarmPageSize: envInt("CACHE_WARM_PAGE_SIZE", 500),
+
+			OnChange: onChange,
+		})
+		orders = memory
+	case "redis":
+		rc, err := cache.NewRedisCache(context.Background(), repo, cache.RedisOptions{
+			Addr:        envOr("REDIS_ADDR", "redis:6379"),
+			Password:    os.Getenv("REDIS_PASSWORD"),
+			DB:          envInt("REDIS_DB", 0),
+			TTL:         envDuration("CACHE_TTL", 10*time.Minute),
+			NegativeTTL: envDuration("CACHE_NEGATIVE_TTL", 30*time.Second),
+			LoadTimeout: envDuration("CACHE_LOAD_TIMEOUT", 5*time.Second),
+
+			WarmMaxEntries: envInt("CACHE_MAX_ENTRIES", 100000),
+			WarmPageSize:   envInt("CACHE_WARM_PAGE_SIZE", 500),
+
+			OnChange: onChange,
+		})
+		if err != nil {
+			log.Fatal("cant connect to redis:", err)
+		}
+		defer rc.Close()
+		orders = rc
+	default:
+		log.Fatalf("unknown CACHE_BACKEND %q", backend)
+	}
+	expvar.Publish("cache", expvar.Func(func() any { return orders.Stats() }))
 
 	tmplIndex := template.Must(template.ParseFiles("internal/templates/index.html"))
 	tmplView := template.Must(template.ParseFiles("internal/templates/view.html"))
 
-	handler := api.NewOrderHandler(tmplIndex, tmplView, cache, repo)
+	// запись заказов через HTTP — только с токеном: ORDER_WRITE_TOKEN или,
+	// если он не задан, ADMIN_TOKEN
+	writeToken := envOr("ORDER_WRITE_TOKEN", os.Getenv("ADMIN_TOKEN"))
+	if writeToken == "" {
+		log.Println("ORDER_WRITE_TOKEN and ADMIN_TOKEN are not set, POST /order and PUT /order/{uid} disabled")
+	}
+	handler := api.NewOrderHandler(tmplIndex, tmplView, orders, repo, writeToken)
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	// SIGTERM/SIGINT останавливают фоновые задачи и серверы; финальный снимок
+	// кэша пишется до выхода
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	reader := consumer.NewReader(
-		consumer.Config{
-			Brokers: []string{os.Getenv("KAFKA_URL")},
-			Topic:   "orders",
-			GroupID: "order-service",
-		},
-		repo,
-		cache,
-	)
+	snapshotPath := os.Getenv("CACHE_SNAPSHOT_FILE")
+	warm := func() {
+		if snapshotPath != "" {
+			found, err := memory.LoadSnapshot(ctx, snapshotPath)
+			if err != nil {
+				log.Printf("cache snapshot load error: %v", err)
+			}
+			if found && err == nil {
+				return
+			}
+		}
+		if _, err := memory.Warm(ctx); err != nil {
+			log.Printf("cache warm-up error: %v", err)
+		}
+	}
+	switch {
+	case memory == nil:
+	case envBool("HTTP_WAIT_FOR_WARMUP", false):
+		// сервер начнёт принимать запросы только с прогретым кэшем
+		warm()
+	default:
+		go warm()
+	}
+
+	retryStages, err := consumer.ParseRetryStages(envOr("KAFKA_RETRY_STAGES", "orders.retry.5s=5s,orders.retry.1m=1m"))
+	if err != nil {
+		log.Fatal("bad KAFKA_RETRY_STAGES:", err)
+	}
+
+	opts := consumer.Options{
+		Concurrency:  envInt("KAFKA_CONCURRENCY", 4),
+		BatchSize:    envInt("KAFKA_BATCH_SIZE", 100),
+		BatchTimeout: envDuration("KAFKA_BATCH_TIMEOUT", 50*time.Millisecond),
+	}
+
+	src := consumer.NewKafkaSource(consumer.Config{
+		Brokers:     []string{os.Getenv("KAFKA_URL")},
+		Topic:       "orders",
+		GroupID:     "order-service",
+		DLQTopic:    envOr("KAFKA_DLQ_TOPIC", "orders.dlq"),
+		RetryStages: retryStages,
+	})
+	reader := consumer.NewReader(src, opts, repo, orders)
 	go reader.Start(ctx)
 
+	if invalidator != nil {
+		go invalidator.Listen(ctx, orders)
+	}
+
+	// отметки об обработанных сообщениях нужны, пока сообщение может прийти
+	// повторно; старые удаляются, чтобы таблица не росла бесконечно
+	go repo.RunProcessedCleanup(ctx,
+		envDuration("PROCESSED_MESSAGES_RETENTION", 7*24*time.Hour),
+		envDuration("PROCESSED_MESSAGES_CLEANUP_INTERVAL", time.Hour),
+		envInt("PROCESSED_MESSAGES_CLEANUP_BATCH", 10000))
+
+	snapshotsDone := make(chan struct{})
+	if memory != nil && snapshotPath != "" {
+		go func() {
+			defer close(snapshotsDone)
+			memory.RunSnapshots(ctx, snapshotPath, envDuration("CACHE_SNAPSHOT_INTERVAL", 5*time.Minute))
+		}()
+	} else {
+		close(snapshotsDone)
+	}
+
+	if path := os.Getenv("ORDERS_BACKFILL_FILE"); path != "" {
+		fileSrc, err := consumer.NewFileSource(path)
+		if err != nil {
+			log.Fatal("cant open backfill file:", err)
+		}
+		go func() {
+			consumer.NewReader(fileSrc, opts, repo, orders).Start(ctx)
+			log.Printf("backfill from %s done", path)
+		}()
+	}
+
+	var admin *http.Server
+	if token := os.Getenv("ADMIN_TOKEN"); token != "" {
+		admin = &http.Server{
+			Addr:         envOr("ADMIN_ADDR", ":8082"),
+			Handler:      api.NewAdminHandler(ctx, orders, token).Routes(),
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 30 * time.Second,
+		}
+		go func() {
+			log.Printf("Admin server listens: %s", admin.Addr)
+			if err := admin.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+	} else {
+		log.Println("ADMIN_TOKEN is not set, admin server disabled")
+	}
+
 	srv := &http.Server{
 		Addr:         ":8081",
 		Handler:      handler.Routes(),
@@ -55,8 +232,63 @@ func main() {
 		IdleTimeout:  60 * time.Second,
 	}
 
+	go func() {
+		<-ctx.Done()
+		log.Println("shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+		if admin != nil {
+			_ = admin.Shutdown(shutdownCtx)
+		}
+	}()
+
 	log.Println("Server listens: 8081")
 	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatal(err)
 	}
+	<-snapshotsDone
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func envInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatalf("bad %s: %v", key, err)
+	}
+	return n
+}
+
+func envBool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Fatalf("bad %s: %v", key, err)
+	}
+	return b
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("bad %s: %v", key, err)
+	}
+	return d
 }
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/CodenSell/WB_test_level0/internal/storage/postgres"
+)
+
+const migrateUsage = "usage: app migrate [up | down [N] | status]"
+
+// runMigrate — подкоманда migrate: накатить, откатить или показать миграции.
+func runMigrate(repo *postgres.Repository, args []string) {
+	ctx := context.Background()
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+
+	switch {
+	case cmd == "up" && len(args) <= 1:
+		applied, err := repo.MigrateUp(ctx)
+		if err != nil {
+			log.Fatal("migrate up: ", err)
+		}
+		if len(applied) == 0 {
+			log.Println("schema is up to date")
+		}
+		for _, m := range applied {
+			log.Printf("applied %04d_%s", m.Version, m.Name)
+		}
+	case cmd == "down" && len(args) <= 2:
+		steps := 1
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				log.Fatal(migrateUsage)
+			}
+			steps = n
+		}
+		reverted, err := repo.MigrateDown(ctx, steps)
+		if err != nil {
+			log.Fatal("migrate down: ", err)
+		}
+		for _, m := range reverted {
+			log.Printf("reverted %04d_%s", m.Version, m.Name)
+		}
+	case cmd == "status" && len(args) == 1:
+		states, err := repo.MigrationStatus(ctx)
+		if err != nil {
+			log.Fatal("migrate status: ", err)
+		}
+		for _, s := range states {
+			applied := "pending"
+			if s.Applied {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(os.Stdout, "%04d_%-24s %s\n", s.Version, s.Name, applied)
+		}
+	default:
+		log.Fatal(migrateUsage)
+	}
+}
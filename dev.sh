@@ -4,7 +4,15 @@ docker build --tag go_orders_backend:latest .
 
 docker compose up -d
 
+for topic in orders orders.retry.5s orders.retry.1m orders.dlq; do
+    docker exec broker /opt/kafka/bin/kafka-topics.sh \
+        --bootstrap-server localhost:9092 \
+        --create --if-not-exists --topic "$topic" \
+        --replication-factor 1 --partitions 1
+done
+
 docker exec broker /opt/kafka/bin/kafka-topics.sh \
     --bootstrap-server localhost:9092 \
-    --create --if-not-exists --topic orders \
-    --replication-factor 1 --partitions 1
\ No newline at end of file
+    --create --if-not-exists --topic orders.invalidations \
+    --replication-factor 1 --partitions 1 \
+    --config cleanup.policy=compact
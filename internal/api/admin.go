@@ -0,0 +1,152 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"expvar"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/CodenSell/WB_test_level0/internal/cache"
+)
+
+const (
+	defaultAdminPageSize = 100
+	maxAdminPageSize     = 1000
+)
+
+// AdminHandler — служебные эндпоинты кэша. Вешается на отдельный порт и
+// требует заголовок Authorization: Bearer <token>.
+type AdminHandler struct {
+	// base — контекст сервера: фоновые задачи, запущенные запросом (прогрев),
+	// переживают сам запрос, но останавливаются вместе с сервером.
+	base    context.Context
+	cache   cache.OrderCache
+	token   string
+	warming atomic.Bool
+}
+
+func NewAdminHandler(base context.Context, cache cache.OrderCache, token string) *AdminHandler {
+	return &AdminHandler{base: base, cache: cache, token: token}
+}
+
+func (a *AdminHandler) Routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/cache/stats", a.handleStats)
+	mux.HandleFunc("GET /admin/cache/orders", a.handleList)
+	mux.HandleFunc("DELETE /admin/cache/orders/{uid}", a.handleEvict)
+	mux.HandleFunc("POST /admin/cache/flush", a.handleFlush)
+	mux.HandleFunc("POST /admin/cache/warm", a.handleWarm)
+	// expvar отдаёт memstats, cmdline и счётчики кэша — только за токеном
+	mux.Handle("GET /debug/vars", expvar.Handler())
+	return a.auth(mux)
+}
+
+func (a *AdminHandler) auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r, a.token, "admin") {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authorized проверяет заголовок Authorization: Bearer <token> и при
+// несовпадении сам отвечает 401. Пустой token не пускает никого.
+func authorized(w http.ResponseWriter, r *http.Request, token, realm string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" || !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+realm+`"`)
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+		return false
+	}
+	return true
+}
+
+// handleStats — GET /admin/cache/stats: число записей, примерный объём и счётчики.
+func (a *AdminHandler) handleStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.cache.Stats())
+}
+
+type uidPage struct {
+	UIDs []string `json:"uids"`
+	// Next — значение after для следующей страницы; пустое — страница последняя.
+	Next string `json:"next,omitempty"`
+}
+
+// handleList — GET /admin/cache/orders?after=uid&limit=N: закэшированные uid по возрастанию.
+func (a *AdminHandler) handleList(w http.ResponseWriter, r *http.Request) {
+	limit := defaultAdminPageSize
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAdminPageSize {
+			writeJSONError(w, http.StatusBadRequest, "limit must be 1.."+strconv.Itoa(maxAdminPageSize))
+			return
+		}
+		limit = n
+	}
+
+	// берём на один больше, чтобы понять, есть ли следующая страница
+	uids, err := a.cache.UIDs(r.Context(), r.URL.Query().Get("after"), limit+1)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	page := uidPage{UIDs: uids}
+	if len(uids) > limit {
+		page.UIDs = uids[:limit]
+		page.Next = uids[limit-1]
+	}
+	if page.UIDs == nil {
+		page.UIDs = []string{}
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// handleEvict — DELETE /admin/cache/orders/{uid}: убирает заказ из кэша, в БД он остаётся.
+func (a *AdminHandler) handleEvict(w http.ResponseWriter, r *http.Request) {
+	if !a.cache.Invalidate(r.Context(), r.PathValue("uid")) {
+		writeJSONError(w, http.StatusNotFound, "not cached")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleFlush — POST /admin/cache/flush: очищает кэш целиком.
+func (a *AdminHandler) handleFlush(w http.ResponseWriter, r *http.Request) {
+	if err := a.cache.Flush(r.Context()); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	log.Printf("admin: cache flushed")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleWarm — POST /admin/cache/warm: запускает прогрев из БД в фоне;
+// пока он идёт, повторный запрос получает 409.
+func (a *AdminHandler) handleWarm(w http.ResponseWriter, r *http.Request) {
+	if !a.warming.CompareAndSwap(false, true) {
+		writeJSONError(w, http.StatusConflict, "warm-up already running")
+		return
+	}
+	go func() {
+		defer a.warming.Store(false)
+		n, err := a.cache.Warm(a.base)
+		if err != nil {
+			log.Printf("admin: cache warm-up error after %d orders: %v", n, err)
+			return
+		}
+		log.Printf("admin: cache re-warmed with %d orders", n)
+	}()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/CodenSell/WB_test_level0/internal/cache"
+	"github.com/CodenSell/WB_test_level0/internal/storage/storagetest"
+	"github.com/CodenSell/WB_test_level0/internal/structs"
+)
+
+const testAdminToken = "s3cret"
+
+func newTestAdmin(t *testing.T) (http.Handler, *cache.Cache, *storagetest.Repo) {
+	t.Helper()
+	repo := storagetest.NewRepo()
+	c := cache.NewCache(repo, "", cache.Options{})
+	return NewAdminHandler(context.Background(), c, testAdminToken).Routes(), c, repo
+}
+
+func adminDo(t *testing.T, h http.Handler, method, target, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdmin_RequiresToken(t *testing.T) {
+	h, _, _ := newTestAdmin(t)
+	for _, token := range []string{"", "wrong"} {
+		for _, path := range []string{"/admin/cache/stats", "/debug/vars"} {
+			if rec := adminDo(t, h, http.MethodGet, path, token); rec.Code != http.StatusUnauthorized {
+				t.Fatalf("%s with token %q: status %d, want 401", path, token, rec.Code)
+			}
+		}
+	}
+	if rec := adminDo(t, h, http.MethodGet, "/debug/vars", testAdminToken); rec.Code != http.StatusOK {
+		t.Fatalf("/debug/vars: status %d, want 200", rec.Code)
+	}
+
+	// без настроенного токена админка закрыта целиком
+	open := NewAdminHandler(context.Background(), cache.NewCache(storagetest.NewRepo(), "", cache.Options{}), "").Routes()
+	if rec := adminDo(t, open, http.MethodGet, "/admin/cache/stats", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("empty token: status %d, want 401", rec.Code)
+	}
+}
+
+func TestAdmin_StatsListEvictFlush(t *testing.T) {
+	h, c, _ := newTestAdmin(t)
+	ctx := context.Background()
+	for _, uid := range []string{"c", "a", "b"} {
+		c.SetOrder(ctx, &structs.Order{OrderUID: uid})
+	}
+
+	rec := adminDo(t, h, http.MethodGet, "/admin/cache/stats", testAdminToken)
+	var st cache.Stats
+	if err := json.NewDecoder(rec.Body).Decode(&st); err != nil || st.Entries != 3 || st.Bytes == 0 {
+		t.Fatalf("stats: %+v %v", st, err)
+	}
+
+	rec = adminDo(t, h, http.MethodGet, "/admin/cache/orders?limit=2", testAdminToken)
+	var page uidPage
+	if err := json.NewDecoder(rec.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.UIDs) != 2 || page.UIDs[0] != "a" || page.UIDs[1] != "b" || page.Next != "b" {
+		t.Fatalf("first page: %+v", page)
+	}
+	rec = adminDo(t, h, http.MethodGet, "/admin/cache/orders?limit=2&after="+page.Next, testAdminToken)
+	page = uidPage{}
+	_ = json.NewDecoder(rec.Body).Decode(&page)
+	if len(page.UIDs) != 1 || page.UIDs[0] != "c" || page.Next != "" {
+		t.Fatalf("second page: %+v", page)
+	}
+
+	if rec := adminDo(t, h, http.MethodDelete, "/admin/cache/orders/a", testAdminToken); rec.Code != http.StatusNoContent {
+		t.Fatalf("evict: status %d", rec.Code)
+	}
+	if rec := adminDo(t, h, http.MethodDelete, "/admin/cache/orders/a", testAdminToken); rec.Code != http.StatusNotFound {
+		t.Fatalf("evict again: status %d", rec.Code)
+	}
+
+	if rec := adminDo(t, h, http.MethodPost, "/admin/cache/flush", testAdminToken); rec.Code != http.StatusNoContent {
+		t.Fatalf("flush: status %d", rec.Code)
+	}
+	if st := c.Stats(); st.Entries != 0 {
+		t.Fatalf("entries after flush: %d", st.Entries)
+	}
+}
+
+func TestAdmin_Warm(t *testing.T) {
+	h, c, repo := newTestAdmin(t)
+	repo.Put(structs.Order{OrderUID: "x"})
+	repo.Put(structs.Order{OrderUID: "y"})
+
+	if rec := adminDo(t, h, http.MethodPost, "/admin/cache/warm", testAdminToken); rec.Code != http.StatusAccepted {
+		t.Fatalf("warm: status %d", rec.Code)
+	}
+	deadline := time.Now().Add(time.Second)
+	for c.Stats().Entries != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("cache not re-warmed: %+v", c.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAdmin_WarmStopsWithServer(t *testing.T) {
+	repo := storagetest.NewRepo("x", "y")
+	c := cache.NewCache(repo, "", cache.Options{})
+	base, cancel := context.WithCancel(context.Background())
+	cancel()
+	h := NewAdminHandler(base, c, testAdminToken).Routes()
+
+	if rec := adminDo(t, h, http.MethodPost, "/admin/cache/warm", testAdminToken); rec.Code != http.StatusAccepted {
+		t.Fatalf("warm: status %d", rec.Code)
+	}
+	// прогрев на остановленном сервере сразу завершается, ничего не загрузив
+	deadline := time.Now().Add(time.Second)
+	for adminDo(t, h, http.MethodPost, "/admin/cache/warm", testAdminToken).Code == http.StatusConflict {
+		if time.Now().After(deadline) {
+			t.Fatal("warm-up did not stop after the server context was cancelled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if n := c.Stats().Entries; n != 0 {
+		t.Fatalf("warm-up loaded %d orders after shutdown", n)
+	}
+}
@@ -1,31 +1,48 @@
 package api
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"html/template"
+	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/CodenSell/WB_test_level0/internal/cache"
+	"github.com/CodenSell/WB_test_level0/internal/diff"
+	"github.com/CodenSell/WB_test_level0/internal/storage"
 	"github.com/CodenSell/WB_test_level0/internal/storage/postgres"
+	"github.com/CodenSell/WB_test_level0/internal/structs"
+	"github.com/CodenSell/WB_test_level0/internal/validation"
 )
 
+const maxOrderBody = 10 << 20
+
 type OrderHandler struct {
 	tmplIndex *template.Template
 	tmplView  *template.Template
-	cache     *cache.Cache
+	cache     cache.OrderCache
 	repo      *postgres.Repository
+	// writeToken — токен для POST /order и PUT /order/{uid} (заголовок
+	// Authorization: Bearer <token>); пустой — запись через HTTP закрыта.
+	writeToken string
 }
 
-func NewOrderHandler(tmplIndex *template.Template, tmplView *template.Template, cache *cache.Cache, repo *postgres.Repository) *OrderHandler {
-	return &OrderHandler{tmplIndex: tmplIndex, tmplView: tmplView, cache: cache, repo: repo}
+func NewOrderHandler(tmplIndex *template.Template, tmplView *template.Template, cache cache.OrderCache, repo *postgres.Repository, writeToken string) *OrderHandler {
+	return &OrderHandler{tmplIndex: tmplIndex, tmplView: tmplView, cache: cache, repo: repo, writeToken: writeToken}
 }
 
 func (a *OrderHandler) Routes() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", a.handleIndex)
 	mux.HandleFunc("/view", a.handleView)
+	mux.HandleFunc("/order", a.handleCreate)
 	mux.HandleFunc("/order/", a.handleAPI)
+	mux.HandleFunc("/orders", a.handleList)
+	mux.HandleFunc("/orders/", a.handleLookup)
 	return mux
 }
 
@@ -38,6 +55,16 @@ func (a *OrderHandler) handleIndex(w http.ResponseWriter, r *http.Request) {
 	_ = a.tmplIndex.Execute(w, nil)
 }
 
+type viewData struct {
+	Order    *structs.Order
+	Revision *storage.Revision
+	// Revisions — список истории; пустой, если его не удалось прочитать.
+	Revisions []storage.RevisionInfo
+	// Diff — изменения ревизии относительно предыдущей (DiffFrom).
+	Diff     *diff.OrderDiff
+	DiffFrom int
+}
+
 func (a *OrderHandler) handleView(w http.ResponseWriter, r *http.Request) {
 	uid := strings.TrimSpace(r.URL.Query().Get("order_uid"))
 	if uid == "" {
@@ -45,27 +72,85 @@ func (a *OrderHandler) handleView(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	order, found, err := a.cache.GetOrder(r.Context(), uid)
-	if err != nil {
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		http.Error(w, "internal error", http.StatusInternalServerError)
-		return
+	var data viewData
+	if rev := r.URL.Query().Get("rev"); rev != "" {
+		n, err := strconv.Atoi(rev)
+		if err != nil || n < 1 {
+			http.Error(w, "bad rev", http.StatusBadRequest)
+			return
+		}
+		revision, err := a.repo.GetRevision(r.Context(), uid, n)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "revision not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		data.Order, data.Revision = &revision.Order, revision
+
+		if n > 1 {
+			prev, err := a.repo.GetRevision(r.Context(), uid, n-1)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "internal error", http.StatusInternalServerError)
+				return
+			}
+			if err == nil {
+				d := diff.Orders(prev.Order, revision.Order)
+				data.Diff, data.DiffFrom = &d, n-1
+			}
+		}
+	} else {
+		order, found, err := a.cache.GetOrder(r.Context(), uid)
+		if err != nil {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "order not found", http.StatusNotFound)
+			return
+		}
+		data.Order = order
 	}
-	if !found {
-		http.Error(w, "order not found", http.StatusNotFound)
-		return
+
+	// заказ уже есть (возможно, из кэша); без истории страница всё равно полезна
+	revisions, err := a.repo.ListRevisionInfos(r.Context(), uid)
+	if err != nil {
+		log.Printf("view %s: list revisions: %v", uid, err)
 	}
+	data.Revisions = revisions
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	_ = a.tmplView.Execute(w, order)
+	_ = a.tmplView.Execute(w, data)
 }
 
 func (a *OrderHandler) handleAPI(w http.ResponseWriter, r *http.Request) {
-	uid := strings.TrimPrefix(r.URL.Path, "/order/")
-	if uid == "" || strings.Contains(uid, "/") {
+	uid, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/order/"), "/")
+	if uid == "" || (sub != "" && sub != "history" && sub != "diff") {
 		http.Error(w, "were waiting for path /order/{order_uid}", http.StatusBadRequest)
 		return
 	}
+	switch sub {
+	case "history":
+		a.handleHistory(w, r, uid)
+		return
+	case "diff":
+		a.handleDiff(w, r, uid)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPut:
+		a.handlePut(w, r, uid)
+		return
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
 
 	order, found, err := a.cache.GetOrder(r.Context(), uid)
 	if err != nil {
@@ -85,3 +170,174 @@ func (a *OrderHandler) handleAPI(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	_ = json.NewEncoder(w).Encode(order)
 }
+
+// handleHistory — GET /order/{uid}/history: все принятые ревизии заказа.
+func (a *OrderHandler) handleHistory(w http.ResponseWriter, r *http.Request, uid string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	revisions, err := a.repo.ListRevisions(r.Context(), uid)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if len(revisions) == 0 {
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(revisions)
+}
+
+type diffResponse struct {
+	OrderUID string         `json:"order_uid"`
+	From     int            `json:"from"`
+	To       int            `json:"to"`
+	Diff     diff.OrderDiff `json:"diff"`
+}
+
+// handleDiff — GET /order/{uid}/diff?from=N&to=M: поле за полем, чем ревизия M
+// отличается от ревизии N.
+func (a *OrderHandler) handleDiff(w http.ResponseWriter, r *http.Request, uid string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	from, err1 := strconv.Atoi(r.URL.Query().Get("from"))
+	to, err2 := strconv.Atoi(r.URL.Query().Get("to"))
+	if err1 != nil || err2 != nil || from < 1 || to < 1 {
+		writeJSONError(w, http.StatusBadRequest, "need positive from and to revisions")
+		return
+	}
+
+	revs := make([]*storage.Revision, 2)
+	for i, n := range []int{from, to} {
+		rev, err := a.repo.GetRevision(r.Context(), uid, n)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, http.StatusNotFound, "revision "+strconv.Itoa(n)+" not found")
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		revs[i] = rev
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(diffResponse{
+		OrderUID: uid,
+		From:     from,
+		To:       to,
+		Diff:     diff.Orders(revs[0].Order, revs[1].Order),
+	})
+}
+
+// handleCreate — POST /order: создаёт или обновляет заказ из тела запроса.
+func (a *OrderHandler) handleCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !authorized(w, r, a.writeToken, "orders") {
+		return
+	}
+	o, ok := decodeOrder(w, r)
+	if !ok {
+		return
+	}
+	a.saveOrder(w, r, o)
+}
+
+// handlePut — PUT /order/{uid}: uid в теле должен совпадать с путём или отсутствовать.
+func (a *OrderHandler) handlePut(w http.ResponseWriter, r *http.Request, uid string) {
+	if !authorized(w, r, a.writeToken, "orders") {
+		return
+	}
+	o, ok := decodeOrder(w, r)
+	if !ok {
+		return
+	}
+	if o.OrderUID == "" {
+		o.OrderUID = uid
+	}
+	if o.OrderUID != uid {
+		writeJSONError(w, http.StatusBadRequest, "order_uid in body does not match path")
+		return
+	}
+	a.saveOrder(w, r, o)
+}
+
+func (a *OrderHandler) saveOrder(w http.ResponseWriter, r *http.Request, o *structs.Order) {
+	if err := validation.ValidateOrder(o); err != nil {
+		writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	now := time.Now()
+	meta := storage.Meta{
+		Version:    now.UnixMicro(),
+		Source:     "http",
+		SourceRef:  r.RemoteAddr,
+		ReceivedAt: now,
+	}
+	if v := r.Header.Get("X-Order-Version"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "bad X-Order-Version")
+			return
+		}
+		if n > now.Add(storage.MaxVersionSkew).UnixMicro() {
+			writeJSONError(w, http.StatusBadRequest, "X-Order-Version is in the future: want unix microseconds not after the server time")
+			return
+		}
+		meta.Version = n
+	}
+
+	created, err := a.cache.CreateOrder(r.Context(), o, meta)
+	if errors.Is(err, storage.ErrStale) {
+		writeJSONError(w, http.StatusConflict, "a newer version of the order is already stored")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+		w.Header().Set("Location", "/order/"+o.OrderUID)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(o)
+}
+
+func decodeOrder(w http.ResponseWriter, r *http.Request) (*structs.Order, bool) {
+	var o structs.Order
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxOrderBody)).Decode(&o); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "body too large")
+			return nil, false
+		}
+		writeJSONError(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return nil, false
+	}
+	o.OrderUID = strings.TrimSpace(o.OrderUID)
+	return &o, true
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
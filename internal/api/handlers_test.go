@@ -0,0 +1,181 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/CodenSell/WB_test_level0/internal/cache"
+	"github.com/CodenSell/WB_test_level0/internal/storage/storagetest"
+	"github.com/CodenSell/WB_test_level0/internal/structs"
+)
+
+const testWriteToken = "wr1te"
+
+func newTestHandler(t *testing.T) (http.Handler, *storagetest.Repo) {
+	t.Helper()
+	repo := storagetest.NewRepo()
+	return NewOrderHandler(nil, nil, cache.NewCache(repo, "", cache.Options{}), nil, testWriteToken).Routes(), repo
+}
+
+func testOrder(uid string) structs.Order {
+	return structs.Order{
+		OrderUID: uid,
+		Delivery: structs.Delivery{Email: "a@b.c", Phone: "+1"},
+		Payment:  structs.Payment{Currency: "USD", Amount: 10, GoodsTotal: 10},
+		Items:    []structs.Items{{Name: "Mask", Price: 10, TotalPrice: 10}},
+	}
+}
+
+func do(t *testing.T, h http.Handler, method, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	switch b := body.(type) {
+	case string:
+		buf.WriteString(b)
+	case nil:
+	default:
+		if err := json.NewEncoder(&buf).Encode(b); err != nil {
+			t.Fatalf("encode body: %v", err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Authorization", "Bearer "+testWriteToken)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestPostOrder_CreateThenUpdate(t *testing.T) {
+	h, repo := newTestHandler(t)
+
+	rec := do(t, h, http.MethodPost, "/order", testOrder("u1"))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	if loc := rec.Header().Get("Location"); loc != "/order/u1" {
+		t.Fatalf("bad Location: %q", loc)
+	}
+
+	o := testOrder("u1")
+	o.TrackNumber = "NEW"
+	rec = do(t, h, http.MethodPost, "/order", o)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 on update, got %d: %s", rec.Code, rec.Body)
+	}
+	if o, _ := repo.Order("u1"); o.TrackNumber != "NEW" {
+		t.Fatalf("order was not updated in repo")
+	}
+
+	rec = do(t, h, http.MethodGet, "/order/u1", nil)
+	var got structs.Order
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil || got.TrackNumber != "NEW" {
+		t.Fatalf("cache was not refreshed: %+v %v", got, err)
+	}
+}
+
+func TestWriteOrder_RequiresToken(t *testing.T) {
+	h, repo := newTestHandler(t)
+	body, _ := json.Marshal(testOrder("u1"))
+
+	for _, c := range []struct{ method, path, auth string }{
+		{http.MethodPost, "/order", ""},
+		{http.MethodPost, "/order", "Bearer wrong"},
+		{http.MethodPut, "/order/u1", ""},
+		{http.MethodPut, "/order/u1", "Bearer " + testAdminToken},
+	} {
+		req := httptest.NewRequest(c.method, c.path, bytes.NewReader(body))
+		if c.auth != "" {
+			req.Header.Set("Authorization", c.auth)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("%s %s with %q: status %d, want 401", c.method, c.path, c.auth, rec.Code)
+		}
+	}
+	if _, ok := repo.Order("u1"); ok {
+		t.Fatal("unauthorized write reached the repo")
+	}
+	// чтение токена не требует
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/order/u1", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("GET: status %d, want 404", rec.Code)
+	}
+
+	// без токена запись через HTTP закрыта
+	closed := NewOrderHandler(nil, nil, cache.NewCache(storagetest.NewRepo(), "", cache.Options{}), nil, "").Routes()
+	if rec := do(t, closed, http.MethodPost, "/order", testOrder("u1")); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("empty token: status %d, want 401", rec.Code)
+	}
+}
+
+func TestPutOrder(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	o := testOrder("")
+	if rec := do(t, h, http.MethodPut, "/order/u2", o); rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := do(t, h, http.MethodPut, "/order/u2", testOrder("u2")); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := do(t, h, http.MethodPut, "/order/u2", testOrder("other")); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for uid mismatch, got %d", rec.Code)
+	}
+}
+
+func TestPutOrder_VersionHeader(t *testing.T) {
+	h, repo := newTestHandler(t)
+
+	put := func(version string) int {
+		var buf bytes.Buffer
+		_ = json.NewEncoder(&buf).Encode(testOrder("u4"))
+		req := httptest.NewRequest(http.MethodPut, "/order/u4", &buf)
+		req.Header.Set("X-Order-Version", version)
+		req.Header.Set("Authorization", "Bearer "+testWriteToken)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	future := strconv.FormatInt(time.Now().Add(time.Hour).UnixMicro(), 10)
+	for _, v := range []string{"abc", "-1", "9223372036854775807", future} {
+		if code := put(v); code != http.StatusBadRequest {
+			t.Fatalf("version %s: expected 400, got %d", v, code)
+		}
+	}
+	if repo.Len() != 0 {
+		t.Fatalf("orders with rejected versions must not be stored")
+	}
+	if code := put(strconv.FormatInt(time.Now().UnixMicro(), 10)); code != http.StatusCreated {
+		t.Fatalf("current version: expected 201, got %d", code)
+	}
+}
+
+func TestPostOrder_Rejects(t *testing.T) {
+	h, repo := newTestHandler(t)
+
+	if rec := do(t, h, http.MethodPost, "/order", `{"order_uid":`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for bad json, got %d", rec.Code)
+	}
+	invalid := testOrder("u3")
+	invalid.Items = nil
+	if rec := do(t, h, http.MethodPost, "/order", invalid); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for invalid order, got %d", rec.Code)
+	}
+	if rec := do(t, h, http.MethodDelete, "/order/u3", nil); rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", rec.Code)
+	}
+	if rec := do(t, h, http.MethodGet, "/order/u3", nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a missing order, got %d", rec.Code)
+	}
+	if repo.Len() != 0 {
+		t.Fatalf("rejected orders must not be stored")
+	}
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CodenSell/WB_test_level0/internal/storage"
+	"github.com/CodenSell/WB_test_level0/internal/storage/postgres"
+)
+
+// handleList — GET /orders: страница заказов из БД с фильтрами и сортировкой,
+// следующая страница — по next_cursor из ответа.
+func (a *OrderHandler) handleList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	q, err := parseOrderQuery(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := a.repo.ListOrders(r.Context(), q)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(page)
+}
+
+// lookupPaths — GET /orders/by-<ключ>/{значение} для вторичных ключей.
+var lookupPaths = map[string]storage.LookupKey{
+	"by-track":       storage.ByTrack,
+	"by-transaction": storage.ByTransaction,
+	"by-rid":         storage.ByRid,
+	"by-customer":    storage.ByCustomer,
+}
+
+// handleLookup — GET /orders/by-track/{track}, /by-transaction/{tx}, /by-rid/{rid},
+// /by-customer/{id}: заказы с этим значением из кэша, сначала новые.
+func (a *OrderHandler) handleLookup(w http.ResponseWriter, r *http.Request) {
+	by, value, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/orders/"), "/")
+	key, ok := lookupPaths[by]
+	if !ok || strings.TrimSpace(value) == "" {
+		writeJSONError(w, http.StatusNotFound, "want /orders/{by-track|by-transaction|by-rid|by-customer}/{value}")
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	orders, err := a.cache.Lookup(r.Context(), key, value)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if len(orders) == 0 {
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(orders)
+}
+
+// parseOrderQuery разбирает параметры GET /orders:
+// customer_id, track_number, delivery_service, entry, locale, currency, provider, bank —
+// точное совпадение; created_from и created_to — RFC 3339 или YYYY-MM-DD, [from, to);
+// sort — date_created или order_uid, "-" в начале — по убыванию; limit; cursor.
+func parseOrderQuery(v url.Values) (storage.OrderQuery, error) {
+	q := storage.OrderQuery{Filter: storage.OrderFilter{
+		CustomerID:      v.Get("customer_id"),
+		TrackNumber:     v.Get("track_number"),
+		DeliveryService: v.Get("delivery_service"),
+		Entry:           v.Get("entry"),
+		Locale:          v.Get("locale"),
+		Currency:        v.Get("currency"),
+		Provider:        v.Get("provider"),
+		Bank:            v.Get("bank"),
+	}}
+
+	var err error
+	if q.Filter.CreatedFrom, err = parseDate(v.Get("created_from")); err != nil {
+		return q, errors.New("bad created_from: want RFC 3339 or YYYY-MM-DD")
+	}
+	if q.Filter.CreatedTo, err = parseDate(v.Get("created_to")); err != nil {
+		return q, errors.New("bad created_to: want RFC 3339 or YYYY-MM-DD")
+	}
+
+	if q.Sort, err = storage.ParseOrderSort(v.Get("sort")); err != nil {
+		return q, errors.New("sort must be date_created or order_uid, optionally prefixed with -")
+	}
+
+	q.Limit = postgres.DefaultListLimit
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > postgres.MaxListLimit {
+			return q, errors.New("limit must be 1.." + strconv.Itoa(postgres.MaxListLimit))
+		}
+		q.Limit = n
+	}
+
+	if s := v.Get("cursor"); s != "" {
+		if q.After, err = storage.DecodeCursor(s, q.Sort); err != nil {
+			return q, errors.New("bad cursor: it must come from next_cursor of a request with the same sort")
+		}
+	}
+	return q, nil
+}
+
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, s)
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/CodenSell/WB_test_level0/internal/storage"
+	"github.com/CodenSell/WB_test_level0/internal/structs"
+)
+
+func TestParseOrderQuery(t *testing.T) {
+	next := storage.Cursor{Sort: "order_uid", OrderUID: "u1"}.Encode()
+	q, err := parseOrderQuery(url.Values{
+		"customer_id":  {"cust"},
+		"bank":         {"alpha"},
+		"created_from": {"2021-11-01"},
+		"created_to":   {"2021-12-01T00:00:00+03:00"},
+		"sort":         {"order_uid"},
+		"limit":        {"20"},
+		"cursor":       {next},
+	})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if q.Filter.CustomerID != "cust" || q.Filter.Bank != "alpha" || q.Limit != 20 {
+		t.Fatalf("bad query: %+v", q)
+	}
+	if !q.Filter.CreatedFrom.Equal(time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)) ||
+		!q.Filter.CreatedTo.Equal(time.Date(2021, 11, 30, 21, 0, 0, 0, time.UTC)) {
+		t.Fatalf("bad date range: %v .. %v", q.Filter.CreatedFrom, q.Filter.CreatedTo)
+	}
+	if q.Sort != (storage.OrderSort{Field: "order_uid"}) || q.After == nil || q.After.OrderUID != "u1" {
+		t.Fatalf("bad sort or cursor: %+v %+v", q.Sort, q.After)
+	}
+
+	q, err = parseOrderQuery(url.Values{})
+	if err != nil || q.Sort != storage.DefaultOrderSort || q.Limit == 0 || q.After != nil {
+		t.Fatalf("bad defaults: %+v, %v", q, err)
+	}
+}
+
+func TestParseOrderQuery_Rejects(t *testing.T) {
+	next := storage.Cursor{Sort: "order_uid", OrderUID: "u1"}.Encode()
+	for name, v := range map[string]url.Values{
+		"bad date":        {"created_from": {"yesterday"}},
+		"bad sort":        {"sort": {"amount"}},
+		"zero limit":      {"limit": {"0"}},
+		"huge limit":      {"limit": {"100000"}},
+		"garbage cursor":  {"cursor": {"%%%"}},
+		"cursor for sort": {"cursor": {next}, "sort": {"-date_created"}},
+	} {
+		if _, err := parseOrderQuery(v); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestLookup_BySecondaryKeys(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	for _, uid := range []string{"u1", "u2"} {
+		o := testOrder(uid)
+		o.TrackNumber = "TRACK"
+		o.Items[0].Rid = "rid-" + uid
+		if rec := do(t, h, http.MethodPost, "/order", o); rec.Code != http.StatusCreated {
+			t.Fatalf("create %s: %d %s", uid, rec.Code, rec.Body)
+		}
+	}
+
+	rec := do(t, h, http.MethodGet, "/orders/by-track/TRACK", nil)
+	var got []structs.Order
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil || rec.Code != http.StatusOK || len(got) != 2 {
+		t.Fatalf("by track: %d %+v %v", rec.Code, got, err)
+	}
+	rec = do(t, h, http.MethodGet, "/orders/by-rid/rid-u2", nil)
+	got = nil
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil || len(got) != 1 || got[0].OrderUID != "u2" {
+		t.Fatalf("by rid: %d %+v %v", rec.Code, got, err)
+	}
+
+	for path, code := range map[string]int{
+		"/orders/by-customer/nobody": http.StatusNotFound,
+		"/orders/by-email/a@b.c":     http.StatusNotFound,
+		"/orders/by-track/":          http.StatusNotFound,
+	} {
+		if rec := do(t, h, http.MethodGet, path, nil); rec.Code != code {
+			t.Errorf("%s: expected %d, got %d", path, code, rec.Code)
+		}
+	}
+	if rec := do(t, h, http.MethodPost, "/orders/by-track/TRACK", nil); rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"bytes"
+	"html/template"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CodenSell/WB_test_level0/internal/diff"
+	"github.com/CodenSell/WB_test_level0/internal/storage"
+)
+
+func TestViewTemplate_Revision(t *testing.T) {
+	tmpl := template.Must(template.ParseFiles("../templates/view.html"))
+
+	o := testOrder("u1")
+	info := storage.RevisionInfo{OrderUID: "u1", Revision: 1, Source: "kafka", SourceRef: "orders/0/7", ReceivedAt: time.Now()}
+	rev := storage.Revision{RevisionInfo: info, Order: o}
+	data := viewData{Order: &rev.Order, Revision: &rev, Revisions: []storage.RevisionInfo{info}}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	for _, want := range []string{"Заказ u1", "Ревизия 1", "orders/0/7", "/view?order_uid=u1&rev=1"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("rendered page has no %q", want)
+		}
+	}
+}
+
+func TestViewTemplate_Diff(t *testing.T) {
+	tmpl := template.Must(template.ParseFiles("../templates/view.html"))
+
+	prev, cur := testOrder("u1"), testOrder("u1")
+	cur.TrackNumber = "CHANGED"
+	cur.Payment.Amount++
+	d := diff.Orders(prev, cur)
+	rev := storage.Revision{RevisionInfo: storage.RevisionInfo{OrderUID: "u1", Revision: 2, ReceivedAt: time.Now()}, Order: cur}
+	data := viewData{Order: &rev.Order, Revision: &rev, Diff: &d, DiffFrom: 1}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	for _, want := range []string{"Изменения относительно ревизии 1", "<ins>CHANGED</ins>", "payment.amount"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("rendered page has no %q", want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ChanSource — источник в памяти поверх канала. Закрытие канала означает
+// конец данных. Запоминает подтверждённые и отклонённые сообщения.
+type ChanSource struct {
+	in <-chan []byte
+
+	mu     sync.Mutex
+	seq    int
+	acked  []Message
+	nacked []Nacked
+}
+
+type Nacked struct {
+	Message Message
+	Reason  error
+}
+
+func NewChanSource(in <-chan []byte) *ChanSource {
+	return &ChanSource{in: in}
+}
+
+func (s *ChanSource) Fetch(ctx context.Context) (Message, error) {
+	select {
+	case v, ok := <-s.in:
+		if !ok {
+			return Message{}, io.EOF
+		}
+		s.mu.Lock()
+		s.seq++
+		seq := s.seq
+		s.mu.Unlock()
+		return Message{Value: v, Kind: "chan", Source: fmt.Sprintf("chan:%d", seq), ReceivedAt: time.Now()}, nil
+	case <-ctx.Done():
+		return Message{}, ctx.Err()
+	}
+}
+
+func (s *ChanSource) Ack(ctx context.Context, msgs ...Message) error {
+	s.mu.Lock()
+	s.acked = append(s.acked, msgs...)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *ChanSource) Nack(ctx context.Context, m Message, reason error) error {
+	s.mu.Lock()
+	s.nacked = append(s.nacked, Nacked{Message: m, Reason: reason})
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *ChanSource) Close() error {
+	return nil
+}
+
+func (s *ChanSource) Acked() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.acked...)
+}
+
+func (s *ChanSource) Nacked() []Nacked {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Nacked(nil), s.nacked...)
+}
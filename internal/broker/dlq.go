@@ -0,0 +1,107 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	headerError     = "x-error"
+	headerTopic     = "x-source-topic"
+	headerPartition = "x-source-partition"
+	headerOffset    = "x-source-offset"
+	headerFailedAt  = "x-failed-at"
+)
+
+// errNoDLQ — DLQ не настроен, и отклонённое сообщение некуда переложить.
+var errNoDLQ = errors.New("no dlq topic configured")
+
+// reject отправляет сообщение в DLQ и только после успешной записи коммитит его.
+// Без DLQ сообщение не коммитится: коммиты партиции останавливаются на нём, и
+// после перезапуска оно будет прочитано снова, а не потеряно.
+func (s *KafkaSource) reject(ctx context.Context, st *stream, m kafka.Message, reason error) error {
+	if s.cfg.DLQTopic == "" {
+		return fmt.Errorf("%w, message %s/%d/%d left uncommitted: %v", errNoDLQ, m.Topic, m.Partition, m.Offset, reason)
+	}
+
+	msg := dlqMessage(s.cfg.DLQTopic, m, reason, time.Now())
+	if err := s.publish(ctx, msg); err != nil {
+		return err
+	}
+	log.Printf("message %s/%d/%d sent to %s: %v", m.Topic, m.Partition, m.Offset, s.cfg.DLQTopic, reason)
+	return st.commit(ctx, []kafka.Message{m})
+}
+
+// publish пишет сообщение, повторяя попытки до успеха или отмены ctx,
+// иначе следующий коммит более позднего оффсета потеряет это сообщение.
+func (s *KafkaSource) publish(ctx context.Context, msg kafka.Message) error {
+	backoff := time.Second
+	for {
+		err := s.w.WriteMessages(ctx, msg)
+		if err == nil {
+			return nil
+		}
+		log.Printf("kafka write to %s error: %v", msg.Topic, err)
+		select {
+		case <-time.After(backoff):
+			if backoff < 30*time.Second {
+				backoff *= 2
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func dlqMessage(topic string, m kafka.Message, reason error, at time.Time) kafka.Message {
+	headers := withSource(m, headerError, headerFailedAt)
+	headers = append(headers,
+		kafka.Header{Key: headerError, Value: []byte(reason.Error())},
+		kafka.Header{Key: headerFailedAt, Value: []byte(at.UTC().Format(time.RFC3339Nano))},
+	)
+	return kafka.Message{
+		Topic:   topic,
+		Key:     m.Key,
+		Value:   m.Value,
+		Headers: headers,
+		Time:    m.Time,
+	}
+}
+
+// withSource копирует заголовки m без перечисленных в drop и добавляет исходные
+// топик/партицию/оффсет, если сообщение ещё не было переложено из основного топика.
+func withSource(m kafka.Message, drop ...string) []kafka.Header {
+	headers := make([]kafka.Header, 0, len(m.Headers)+len(drop)+3)
+next:
+	for _, h := range m.Headers {
+		for _, d := range drop {
+			if h.Key == d {
+				continue next
+			}
+		}
+		headers = append(headers, h)
+	}
+	if _, ok := header(m, headerTopic); !ok {
+		headers = append(headers,
+			kafka.Header{Key: headerTopic, Value: []byte(m.Topic)},
+			kafka.Header{Key: headerPartition, Value: []byte(strconv.Itoa(m.Partition))},
+			kafka.Header{Key: headerOffset, Value: []byte(strconv.FormatInt(m.Offset, 10))},
+		)
+	}
+	return headers
+}
+
+func header(m kafka.Message, key string) (string, bool) {
+	for _, h := range m.Headers {
+		if h.Key == key {
+			return string(h.Value), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,61 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestDLQMessage_Headers(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	src := kafka.Message{
+		Topic:     "orders",
+		Partition: 2,
+		Offset:    42,
+		Key:       []byte("uid-1"),
+		Value:     []byte(`{"order_uid":`),
+		Headers: []kafka.Header{
+			{Key: "trace-id", Value: []byte("abc")},
+			{Key: headerError, Value: []byte("old reason")},
+		},
+	}
+
+	m := dlqMessage("orders.dlq", src, errors.New("unmarshal: unexpected EOF"), at)
+
+	if m.Topic != "orders.dlq" || string(m.Key) != "uid-1" || string(m.Value) != string(src.Value) {
+		t.Fatalf("bad message: %+v", m)
+	}
+	want := map[string]string{
+		"trace-id":      "abc",
+		headerError:     "unmarshal: unexpected EOF",
+		headerTopic:     "orders",
+		headerPartition: "2",
+		headerOffset:    "42",
+		headerFailedAt:  "2024-01-02T03:04:05Z",
+	}
+	if len(m.Headers) != len(want) {
+		t.Fatalf("expected %d headers, got %d: %+v", len(want), len(m.Headers), m.Headers)
+	}
+	for _, h := range m.Headers {
+		if want[h.Key] != string(h.Value) {
+			t.Fatalf("header %s = %q, want %q", h.Key, h.Value, want[h.Key])
+		}
+	}
+}
+
+func TestReject_WithoutDLQKeepsOffset(t *testing.T) {
+	s := &KafkaSource{}
+	st := &stream{offsets: newOffsetTracker()}
+	st.offsets.add(0, 7)
+
+	err := s.reject(context.Background(), st, kafka.Message{Topic: "orders", Offset: 7}, errors.New("db is down"))
+	if !errors.Is(err, errNoDLQ) {
+		t.Fatalf("expected errNoDLQ, got %v", err)
+	}
+	if p := st.offsets.parts[0]; len(p.pending) != 1 || p.done[7] {
+		t.Fatalf("message without a DLQ must not be committed")
+	}
+}
@@ -0,0 +1,178 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/CodenSell/WB_test_level0/internal/cache"
+	"github.com/segmentio/kafka-go"
+)
+
+// Invalidation — событие «заказ изменился». Ключ сообщения — order_uid, поэтому
+// в сжимаемом (cleanup.policy=compact) топике остаётся последнее событие по заказу.
+type Invalidation struct {
+	OrderUID string `json:"order_uid"`
+	// Instance — кто записал заказ; свои события экземпляр пропускает.
+	Instance string    `json:"instance"`
+	At       time.Time `json:"at"`
+}
+
+type InvalidationConfig struct {
+	Brokers []string
+	Topic   string
+	// Instance — уникальное имя экземпляра: по нему пропускаются свои события.
+	Instance string
+	// Refresh — перечитывать изменённый заказ из БД, если он был в кэше,
+	// вместо того чтобы просто вытеснить его.
+	Refresh bool
+}
+
+// Invalidator рассылает события об изменении заказов другим экземплярам
+// и применяет их события к своему кэшу.
+type Invalidator struct {
+	cfg InvalidationConfig
+	w   *kafka.Writer
+}
+
+func NewInvalidator(cfg InvalidationConfig) *Invalidator {
+	return &Invalidator{
+		cfg: cfg,
+		w: &kafka.Writer{
+			Addr:         kafka.TCP(cfg.Brokers...),
+			Topic:        cfg.Topic,
+			Balancer:     &kafka.Hash{},
+			BatchTimeout: 10 * time.Millisecond,
+			// запись заказа не ждёт рассылки; ошибки только логируются
+			Async: true,
+			Completion: func(msgs []kafka.Message, err error) {
+				if err != nil {
+					log.Printf("invalidation publish of %d events error: %v", len(msgs), err)
+				}
+			},
+		},
+	}
+}
+
+// Publish отправляет событие об изменении заказа uid.
+func (p *Invalidator) Publish(uid string) {
+	value, err := json.Marshal(Invalidation{OrderUID: uid, Instance: p.cfg.Instance, At: time.Now()})
+	if err != nil {
+		log.Printf("invalidation marshal %s: %v", uid, err)
+		return
+	}
+	if err := p.w.WriteMessages(context.Background(), kafka.Message{Key: []byte(uid), Value: value}); err != nil {
+		log.Printf("invalidation publish %s: %v", uid, err)
+	}
+}
+
+// Listen применяет чужие события к кэшу, пока не отменён ctx. Группа
+// консюмера не нужна: каждый экземпляр читает все разделы топика сам, без
+// сохранения offset'ов, и начинает с конца — более ранние изменения уже
+// учтены прогревом. Разделы, добавленные после запуска, не читаются.
+func (p *Invalidator) Listen(ctx context.Context, c cache.OrderCache) {
+	partitions, ok := p.partitions(ctx)
+	if !ok {
+		return
+	}
+	var wg sync.WaitGroup
+	for _, part := range partitions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.listenPartition(ctx, c, part.ID)
+		}()
+	}
+	wg.Wait()
+}
+
+// partitions ждёт, пока топик станет доступен, и возвращает его разделы;
+// ok == false — ctx отменён раньше.
+func (p *Invalidator) partitions(ctx context.Context) (partitions []kafka.Partition, ok bool) {
+	backoff := time.Second
+	for {
+		var err error
+		for _, broker := range p.cfg.Brokers {
+			partitions, err = kafka.DefaultDialer.LookupPartitions(ctx, "tcp", broker, p.cfg.Topic)
+			if err == nil && len(partitions) > 0 {
+				return partitions, true
+			}
+		}
+		if ctx.Err() != nil {
+			return nil, false
+		}
+		log.Printf("invalidation partitions lookup error: %v", err)
+		if !waitBackoff(ctx, &backoff) {
+			return nil, false
+		}
+	}
+}
+
+func (p *Invalidator) listenPartition(ctx context.Context, c cache.OrderCache, partition int) {
+	r := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   p.cfg.Brokers,
+		Topic:     p.cfg.Topic,
+		Partition: partition,
+		MinBytes:  1,
+		MaxBytes:  1e6,
+	})
+	defer r.Close()
+	if err := r.SetOffset(kafka.LastOffset); err != nil {
+		log.Printf("invalidation partition %d: %v", partition, err)
+		return
+	}
+
+	backoff := time.Second
+	for {
+		m, err := r.ReadMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("invalidation partition %d fetch error: %v", partition, err)
+			if !waitBackoff(ctx, &backoff) {
+				return
+			}
+			continue
+		}
+		backoff = time.Second
+		p.apply(ctx, c, m)
+	}
+}
+
+// waitBackoff ждёт *backoff и удваивает его до 30 секунд; false — ctx отменён.
+func waitBackoff(ctx context.Context, backoff *time.Duration) bool {
+	select {
+	case <-time.After(*backoff):
+		if *backoff < 30*time.Second {
+			*backoff *= 2
+		}
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (p *Invalidator) apply(ctx context.Context, c cache.OrderCache, m kafka.Message) {
+	var ev Invalidation
+	if err := json.Unmarshal(m.Value, &ev); err != nil || ev.OrderUID == "" {
+		// допускаем событие без тела: uid берётся из ключа
+		ev = Invalidation{OrderUID: string(m.Key)}
+	}
+	if ev.OrderUID == "" || (ev.Instance != "" && ev.Instance == p.cfg.Instance) {
+		return
+	}
+	if !p.cfg.Refresh {
+		c.Invalidate(ctx, ev.OrderUID)
+		return
+	}
+	if err := c.Reload(ctx, ev.OrderUID); err != nil {
+		log.Printf("invalidation reload %s: %v", ev.OrderUID, err)
+	}
+}
+
+func (p *Invalidator) Close() error {
+	return p.w.Close()
+}
@@ -0,0 +1,65 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/CodenSell/WB_test_level0/internal/cache"
+	"github.com/CodenSell/WB_test_level0/internal/storage/storagetest"
+	"github.com/CodenSell/WB_test_level0/internal/structs"
+	"github.com/segmentio/kafka-go"
+)
+
+func invalidationMsg(t *testing.T, uid, instance string) kafka.Message {
+	t.Helper()
+	b, err := json.Marshal(Invalidation{OrderUID: uid, Instance: instance})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kafka.Message{Key: []byte(uid), Value: b}
+}
+
+func TestInvalidator_Apply(t *testing.T) {
+	ctx := context.Background()
+	repo := storagetest.NewRepo()
+	c := cache.NewCache(repo, "", cache.Options{})
+	p := &Invalidator{cfg: InvalidationConfig{Instance: "me"}}
+
+	c.SetOrder(context.Background(), &structs.Order{OrderUID: "a"})
+	c.SetOrder(context.Background(), &structs.Order{OrderUID: "b"})
+
+	// своё событие пропускается
+	p.apply(ctx, c, invalidationMsg(t, "a", "me"))
+	if !c.Invalidate(ctx, "a") {
+		t.Fatal("own event evicted the order")
+	}
+
+	p.apply(ctx, c, invalidationMsg(t, "b", "other"))
+	if c.Invalidate(ctx, "b") {
+		t.Fatal("foreign event did not evict the order")
+	}
+
+	// событие без тела — uid из ключа
+	c.SetOrder(context.Background(), &structs.Order{OrderUID: "c"})
+	p.apply(ctx, c, kafka.Message{Key: []byte("c")})
+	if c.Invalidate(ctx, "c") {
+		t.Fatal("key-only event did not evict the order")
+	}
+}
+
+func TestInvalidator_ApplyRefresh(t *testing.T) {
+	ctx := context.Background()
+	repo := storagetest.NewRepo()
+	c := cache.NewCache(repo, "", cache.Options{})
+	p := &Invalidator{cfg: InvalidationConfig{Instance: "me", Refresh: true}}
+
+	c.SetOrder(context.Background(), &structs.Order{OrderUID: "a", TrackNumber: "old"})
+	repo.Put(structs.Order{OrderUID: "a", TrackNumber: "new"})
+
+	p.apply(ctx, c, invalidationMsg(t, "a", "other"))
+	o, found, err := c.GetOrder(ctx, "a")
+	if err != nil || !found || o.TrackNumber != "new" {
+		t.Fatalf("got %+v %v %v", o, found, err)
+	}
+}
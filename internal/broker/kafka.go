@@ -2,53 +2,145 @@ package consumer
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/segmentio/kafka-go"
-
-	"github.com/CodenSell/WB_test_level0/internal/cache"
-	"github.com/CodenSell/WB_test_level0/internal/storage"
-	"github.com/CodenSell/WB_test_level0/internal/structs"
-	"github.com/CodenSell/WB_test_level0/internal/validation"
 )
 
+const headerOrderVersion = "x-order-version"
+
 type Config struct {
 	Brokers []string
 	Topic   string
 	GroupID string
+	// DLQTopic — топик для сообщений, не прошедших разбор или валидацию, и
+	// для исчерпавших повторы; обязателен.
+	DLQTopic string
+	// RetryStages — лестница отложенных повторов при ошибках БД. После последней ступени
+	// (или сразу, если ступеней нет) сообщение уходит в DLQ.
+	RetryStages []RetryStage
+}
+
+// KafkaSource читает основной топик и топики ступеней повторов одной группой.
+type KafkaSource struct {
+	cfg     Config
+	streams []*stream
+	w       *kafka.Writer
+
+	msgs   chan Message
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
 }
 
-type Reader struct {
-	cfg   Config
-	repo  storage.OrderRepo
-	cache *cache.Cache
+// stream — один читаемый топик: основной (stage = -1) или ступень повторов.
+type stream struct {
 	r     *kafka.Reader
+	stage int
+
+	mu      sync.Mutex
+	offsets *offsetTracker
+}
+
+func newStream(cfg Config, topic string, stage int) *stream {
+	return &stream{
+		r:       newKafkaReader(cfg, topic),
+		stage:   stage,
+		offsets: newOffsetTracker(),
+	}
+}
+
+func NewKafkaSource(cfg Config) *KafkaSource {
+	streams := []*stream{newStream(cfg, cfg.Topic, -1)}
+	for i, st := range cfg.RetryStages {
+		streams = append(streams, newStream(cfg, st.Topic, i))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &KafkaSource{
+		cfg:     cfg,
+		streams: streams,
+		w: &kafka.Writer{
+			Addr:         kafka.TCP(cfg.Brokers...),
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+		},
+		msgs:   make(chan Message),
+		cancel: cancel,
+	}
+	for _, st := range streams {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.consume(ctx, st)
+		}()
+	}
+	return s
+}
+
+func newKafkaReader(cfg Config, topic string) *kafka.Reader {
+	return kafka.NewReader(kafka.ReaderConfig{
+		Brokers:  cfg.Brokers,
+		GroupID:  cfg.GroupID,
+		Topic:    topic,
+		MinBytes: 1,
+		MaxBytes: 10e6,
+	})
+}
+
+func (s *KafkaSource) Fetch(ctx context.Context) (Message, error) {
+	select {
+	case m := <-s.msgs:
+		return m, nil
+	case <-ctx.Done():
+		return Message{}, ctx.Err()
+	}
+}
+
+// Ack коммитит оффсеты, только когда обработаны все более ранние сообщения
+// соответствующих партиций.
+func (s *KafkaSource) Ack(ctx context.Context, msgs ...Message) error {
+	byStream := make(map[*stream][]kafka.Message)
+	for _, m := range msgs {
+		km, st := kafkaMessage(m)
+		byStream[st] = append(byStream[st], km)
+	}
+	var errs []error
+	for st, kms := range byStream {
+		errs = append(errs, st.commit(ctx, kms))
+	}
+	return errors.Join(errs...)
 }
 
-func NewReader(cfg Config, repo storage.OrderRepo, cache *cache.Cache) *Reader {
-	return &Reader{
-		cfg:   cfg,
-		repo:  repo,
-		cache: cache,
-		r: kafka.NewReader(kafka.ReaderConfig{
-			Brokers:  cfg.Brokers,
-			GroupID:  cfg.GroupID,
-			Topic:    cfg.Topic,
-			MinBytes: 1,
-			MaxBytes: 10e6,
-		}),
+// Nack отправляет невалидные сообщения в DLQ, а остальные — на следующую ступень повторов.
+func (s *KafkaSource) Nack(ctx context.Context, m Message, reason error) error {
+	km, st := kafkaMessage(m)
+	if errors.Is(reason, ErrInvalid) {
+		return s.reject(ctx, st, km, reason)
 	}
+	return s.retry(ctx, st, km, reason)
 }
 
-func (c *Reader) Start(ctx context.Context) {
-	defer c.r.Close()
+func (s *KafkaSource) Close() error {
+	s.cancel()
+	s.wg.Wait()
+	var errs []error
+	for _, st := range s.streams {
+		errs = append(errs, st.r.Close())
+	}
+	errs = append(errs, s.w.Close())
+	return errors.Join(errs...)
+}
 
+func (s *KafkaSource) consume(ctx context.Context, st *stream) {
 	backoff := time.Second
 
 	for {
-		m, err := c.r.FetchMessage(ctx)
+		m, err := st.r.FetchMessage(ctx)
 		if err != nil {
 			if ctx.Err() != nil {
 				return
@@ -66,31 +158,83 @@ func (c *Reader) Start(ctx context.Context) {
 		}
 		backoff = time.Second
 
-		var o structs.Order
-		if err := json.Unmarshal(m.Value, &o); err != nil {
-			log.Printf("cant unmarshal message: %v", err)
-			_ = c.r.CommitMessages(ctx, m)
-			continue
-		}
-		if err := validation.ValidateOrder(&o); err != nil {
-			log.Printf("skip invalid order: %v", err)
-			_ = c.r.CommitMessages(ctx, m)
-			continue
+		if st.stage >= 0 && !waitUntil(ctx, notBefore(m)) {
+			return
 		}
 
-		if err := c.repo.UpsertOrder(ctx, &o); err != nil {
-			log.Printf("db upsert error: %v", err)
-			continue
-		}
+		st.mu.Lock()
+		st.offsets.add(m.Partition, m.Offset)
+		st.mu.Unlock()
 
-		if err := c.cache.CreateOrder(ctx, &o); err != nil {
-			log.Printf("cache create error: %v", err)
+		select {
+		case s.msgs <- Message{
+			Key:        m.Key,
+			Value:      m.Value,
+			ID:         originID(m),
+			Version:    orderVersion(m),
+			Kind:       "kafka",
+			Source:     fmt.Sprintf("%s/%d/%d", m.Topic, m.Partition, m.Offset),
+			ReceivedAt: time.Now(),
+			ref:        kafkaRef{m: m, st: st},
+		}:
+		case <-ctx.Done():
+			return
 		}
+	}
+}
+
+// originID — topic/partition/offset исходного сообщения: для пришедших из
+// топиков повторов берётся из заголовков x-source-*.
+func originID(m kafka.Message) string {
+	topic, ok := header(m, headerTopic)
+	if !ok {
+		return fmt.Sprintf("%s/%d/%d", m.Topic, m.Partition, m.Offset)
+	}
+	partition, _ := header(m, headerPartition)
+	offset, _ := header(m, headerOffset)
+	return topic + "/" + partition + "/" + offset
+}
 
-		if err := c.r.CommitMessages(ctx, m); err != nil {
-			log.Printf("commit error: %v", err)
+// orderVersion берёт версию из заголовка x-order-version, а без него — время
+// создания сообщения; при перекладывании в повторы время сохраняется.
+func orderVersion(m kafka.Message) int64 {
+	if v, ok := header(m, headerOrderVersion); ok {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return n
 		}
+	}
+	if m.Time.IsZero() {
+		return 0
+	}
+	return m.Time.UnixMicro()
+}
 
-		log.Printf("order %s saved and committed from kafka", o.OrderUID)
+type kafkaRef struct {
+	m  kafka.Message
+	st *stream
+}
+
+func kafkaMessage(m Message) (kafka.Message, *stream) {
+	ref := m.ref.(kafkaRef)
+	return ref.m, ref.st
+}
+
+func (st *stream) commit(ctx context.Context, msgs []kafka.Message) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	last := make(map[int]kafka.Message)
+	for _, m := range msgs {
+		if off, ok := st.offsets.done(m.Partition, m.Offset); ok {
+			last[m.Partition] = kafka.Message{Topic: m.Topic, Partition: m.Partition, Offset: off}
+		}
+	}
+	if len(last) == 0 {
+		return nil
+	}
+	toCommit := make([]kafka.Message, 0, len(last))
+	for _, m := range last {
+		toCommit = append(toCommit, m)
 	}
+	return st.r.CommitMessages(ctx, toCommit...)
 }
@@ -0,0 +1,46 @@
+package consumer
+
+// offsetTracker запоминает порядок выборки сообщений по партициям и отдаёт оффсет,
+// который можно коммитить: все сообщения до него включительно уже обработаны.
+type offsetTracker struct {
+	parts map[int]*partitionOffsets
+}
+
+type partitionOffsets struct {
+	pending []int64
+	done    map[int64]bool
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{parts: make(map[int]*partitionOffsets)}
+}
+
+func (t *offsetTracker) add(partition int, offset int64) {
+	p, ok := t.parts[partition]
+	if !ok || (len(p.pending) > 0 && offset <= p.pending[len(p.pending)-1]) {
+		// новая партиция или повторная выдача после ребаланса: старое состояние неактуально
+		p = &partitionOffsets{done: make(map[int64]bool)}
+		t.parts[partition] = p
+	}
+	p.pending = append(p.pending, offset)
+}
+
+// done отмечает оффсет обработанным и возвращает наибольший оффсет непрерывного
+// обработанного префикса, если он сдвинулся.
+func (t *offsetTracker) done(partition int, offset int64) (int64, bool) {
+	p, ok := t.parts[partition]
+	if !ok || len(p.pending) == 0 || offset < p.pending[0] || offset > p.pending[len(p.pending)-1] {
+		return 0, false
+	}
+	p.done[offset] = true
+
+	var last int64
+	advanced := false
+	for len(p.pending) > 0 && p.done[p.pending[0]] {
+		last = p.pending[0]
+		delete(p.done, last)
+		p.pending = p.pending[1:]
+		advanced = true
+	}
+	return last, advanced
+}
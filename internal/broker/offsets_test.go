@@ -0,0 +1,47 @@
+package consumer
+
+import "testing"
+
+func TestOffsetTracker_CommitsContiguousPrefix(t *testing.T) {
+	tr := newOffsetTracker()
+	for _, off := range []int64{10, 11, 12, 13} {
+		tr.add(0, off)
+	}
+	tr.add(1, 5)
+
+	if _, ok := tr.done(0, 12); ok {
+		t.Fatalf("offset 12 must wait for 10 and 11")
+	}
+	if off, ok := tr.done(0, 10); !ok || off != 10 {
+		t.Fatalf("expected commit 10, got %d %v", off, ok)
+	}
+	if off, ok := tr.done(0, 11); !ok || off != 12 {
+		t.Fatalf("expected commit 12, got %d %v", off, ok)
+	}
+	if off, ok := tr.done(1, 5); !ok || off != 5 {
+		t.Fatalf("partition 1: expected commit 5, got %d %v", off, ok)
+	}
+	if off, ok := tr.done(0, 13); !ok || off != 13 {
+		t.Fatalf("expected commit 13, got %d %v", off, ok)
+	}
+}
+
+func TestOffsetTracker_ResetOnRedelivery(t *testing.T) {
+	tr := newOffsetTracker()
+	tr.add(0, 10)
+	tr.add(0, 11)
+
+	// после ребаланса партиция снова отдаёт 10
+	tr.add(0, 10)
+	if off, ok := tr.done(0, 10); !ok || off != 10 {
+		t.Fatalf("expected commit 10 after reset, got %d %v", off, ok)
+	}
+	if _, ok := tr.done(0, 11); ok {
+		t.Fatalf("offset 11 belongs to the stale state and must not be committed")
+	}
+	tr.add(0, 11)
+	tr.add(0, 12)
+	if _, ok := tr.done(0, 12); ok {
+		t.Fatalf("offset 12 must wait for the refetched 11")
+	}
+}
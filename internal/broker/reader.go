@@ -0,0 +1,223 @@
+package consumer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/CodenSell/WB_test_level0/internal/cache"
+	"github.com/CodenSell/WB_test_level0/internal/storage"
+	"github.com/CodenSell/WB_test_level0/internal/structs"
+	"github.com/CodenSell/WB_test_level0/internal/validation"
+)
+
+var (
+	// duplicates считает сообщения, пропущенные как уже применённые.
+	duplicates = expvar.NewInt("consumer_duplicates_total")
+	// stale считает сообщения, пропущенные из-за более новой версии заказа в БД.
+	stale = expvar.NewInt("consumer_stale_total")
+)
+
+type Options struct {
+	// Concurrency — число воркеров. Сообщения одного order_uid всегда попадают в один воркер.
+	Concurrency int
+	// BatchSize и BatchTimeout — воркер копит до BatchSize сообщений, но не дольше
+	// BatchTimeout, и пишет их в БД одной транзакцией.
+	BatchSize    int
+	BatchTimeout time.Duration
+}
+
+// Reader читает заказы из источника: разбор → валидация → запись в БД → кэш → Ack.
+type Reader struct {
+	opts  Options
+	src   OrderSource
+	repo  storage.OrderRepo
+	cache cache.OrderCache
+}
+
+func NewReader(src OrderSource, opts Options, repo storage.OrderRepo, cache cache.OrderCache) *Reader {
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 1
+	}
+	return &Reader{
+		opts:  opts,
+		src:   src,
+		repo:  repo,
+		cache: cache,
+	}
+}
+
+// Start обрабатывает сообщения, пока не отменён ctx или не исчерпан источник.
+func (c *Reader) Start(ctx context.Context) {
+	defer func() {
+		if err := c.src.Close(); err != nil {
+			log.Printf("source close error: %v", err)
+		}
+	}()
+
+	pool := c.startWorkers(ctx)
+	defer pool.stop()
+
+	for {
+		m, err := c.src.Fetch(ctx)
+		if err != nil {
+			if ctx.Err() == nil && !errors.Is(err, io.EOF) {
+				log.Printf("source fetch error: %v", err)
+			}
+			return
+		}
+		if !pool.dispatch(ctx, m) {
+			return
+		}
+	}
+}
+
+func (c *Reader) handle(ctx context.Context, m Message) {
+	o, ok := c.decode(ctx, m)
+	if !ok {
+		return
+	}
+	c.save(ctx, m, o)
+}
+
+// handleBatch пишет пачку заказов одной транзакцией и подтверждает их вместе.
+// Если транзакция не прошла, заказы пишутся по одному, чтобы в повторы
+// ушли только действительно проблемные сообщения.
+func (c *Reader) handleBatch(ctx context.Context, msgs []Message) {
+	if len(msgs) == 1 {
+		c.handle(ctx, msgs[0])
+		return
+	}
+
+	valid := make([]Message, 0, len(msgs))
+	writes := make([]storage.OrderWrite, 0, len(msgs))
+	for _, m := range msgs {
+		if o, ok := c.decode(ctx, m); ok {
+			valid = append(valid, m)
+			writes = append(writes, storage.OrderWrite{Order: o, Meta: c.meta(m)})
+		}
+	}
+	if len(writes) == 0 {
+		return
+	}
+
+	results, err := c.repo.UpsertOrders(ctx, writes)
+	if err != nil {
+		log.Printf("db batch upsert error, falling back to single upserts: %v", err)
+		for i, m := range valid {
+			c.save(ctx, m, writes[i].Order)
+		}
+		return
+	}
+
+	saved := 0
+	for i, w := range writes {
+		if c.skipped(w.Order, valid[i], results[i]) {
+			continue
+		}
+		c.cache.SetOrder(ctx, w.Order)
+		saved++
+	}
+	c.ack(ctx, valid...)
+
+	log.Printf("batch of %d orders saved and acked", saved)
+}
+
+// decode разбирает и валидирует сообщение; негодные, в том числе с версией из
+// будущего, отклоняются с ErrInvalid.
+func (c *Reader) decode(ctx context.Context, m Message) (*structs.Order, bool) {
+	var o structs.Order
+	if err := json.Unmarshal(m.Value, &o); err != nil {
+		log.Printf("cant unmarshal message %s: %v", m.Source, err)
+		c.nack(ctx, m, fmt.Errorf("%w: unmarshal: %v", ErrInvalid, err))
+		return nil, false
+	}
+	if err := validation.ValidateOrder(&o); err != nil {
+		log.Printf("skip invalid order from %s: %v", m.Source, err)
+		c.nack(ctx, m, fmt.Errorf("%w: validation: %v", ErrInvalid, err))
+		return nil, false
+	}
+	if limit := time.Now().Add(storage.MaxVersionSkew).UnixMicro(); m.Version > limit {
+		log.Printf("skip order %s from %s: version %d is in the future", o.OrderUID, m.Source, m.Version)
+		c.nack(ctx, m, fmt.Errorf("%w: version %d is after the server time", ErrInvalid, m.Version))
+		return nil, false
+	}
+	return &o, true
+}
+
+func (c *Reader) save(ctx context.Context, m Message, o *structs.Order) {
+	if _, err := c.repo.UpsertOrder(ctx, o, c.meta(m)); err != nil {
+		if c.skipped(o, m, err) {
+			c.ack(ctx, m)
+			return
+		}
+		log.Printf("db upsert error: %v", err)
+		c.nack(ctx, m, err)
+		return
+	}
+
+	c.cache.SetOrder(ctx, o)
+
+	c.ack(ctx, m)
+
+	log.Printf("order %s from %s saved and acked", o.OrderUID, m.Source)
+}
+
+// skipped сообщает, что запись пропущена как дубликат или устаревшая версия.
+func (c *Reader) skipped(o *structs.Order, m Message, err error) bool {
+	switch {
+	case errors.Is(err, storage.ErrDuplicate):
+		duplicates.Add(1)
+		log.Printf("order %s from %s is a duplicate, skipped", o.OrderUID, m.Source)
+	case errors.Is(err, storage.ErrStale):
+		stale.Add(1)
+		log.Printf("order %s from %s is stale, skipped", o.OrderUID, m.Source)
+	default:
+		return false
+	}
+	return true
+}
+
+// meta — сведения о сообщении для репозитория. Источники без собственного
+// идентификатора дедуплицируются по хэшу места в источнике (файл и строка,
+// номер в канале) вместе с содержимым: повторный прогон того же файла
+// пропускается, а то же содержимое на другой строке (A→B→A) — нет.
+func (c *Reader) meta(m Message) storage.Meta {
+	id := m.ID
+	if id == "" {
+		h := sha256.New()
+		h.Write([]byte(m.Source))
+		h.Write([]byte{0})
+		h.Write(m.Value)
+		id = "sha256:" + hex.EncodeToString(h.Sum(nil))
+	}
+	return storage.Meta{
+		MessageID:  id,
+		Version:    m.Version,
+		Source:     m.Kind,
+		SourceRef:  m.Source,
+		ReceivedAt: m.ReceivedAt,
+	}
+}
+
+func (c *Reader) ack(ctx context.Context, msgs ...Message) {
+	if err := c.src.Ack(ctx, msgs...); err != nil {
+		log.Printf("ack error: %v", err)
+	}
+}
+
+func (c *Reader) nack(ctx context.Context, m Message, reason error) {
+	if err := c.src.Nack(ctx, m, reason); err != nil {
+		log.Printf("nack %s error: %v", m.Source, err)
+	}
+}
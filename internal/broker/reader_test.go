@@ -0,0 +1,209 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CodenSell/WB_test_level0/internal/cache"
+	"github.com/CodenSell/WB_test_level0/internal/storage/storagetest"
+	"github.com/CodenSell/WB_test_level0/internal/structs"
+)
+
+func orderJSON(t *testing.T, uid string) []byte {
+	t.Helper()
+	b, err := json.Marshal(structs.Order{
+		OrderUID: uid,
+		Delivery: structs.Delivery{Email: "a@b.c", Phone: "+1"},
+		Payment:  structs.Payment{Currency: "USD", Amount: 10, GoodsTotal: 10},
+		Items:    []structs.Items{{Name: "Mask", Price: 10, TotalPrice: 10}},
+	})
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return b
+}
+
+func runReader(t *testing.T, src OrderSource, opts Options, repo *storagetest.Repo) *cache.Cache {
+	t.Helper()
+	c := cache.NewCache(repo, "", cache.Options{})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	NewReader(src, opts, repo, c).Start(ctx)
+	if ctx.Err() != nil {
+		t.Fatalf("reader did not stop at the end of the source")
+	}
+	return c
+}
+
+func TestReader_ChanSource_Pipeline(t *testing.T) {
+	repo := storagetest.NewRepo()
+	repo.Fail["broken"] = true
+
+	in := make(chan []byte, 4)
+	in <- orderJSON(t, "ok")
+	in <- []byte(`{"order_uid":`)
+	in <- []byte(`{"order_uid":"no-items","delivery":{"email":"a@b.c","phone":"1"}}`)
+	in <- orderJSON(t, "broken")
+	close(in)
+	src := NewChanSource(in)
+
+	c := runReader(t, src, Options{Concurrency: 2}, repo)
+
+	if _, ok := repo.Order("ok"); !ok {
+		t.Fatalf("order was not upserted")
+	}
+	if _, found, err := c.GetOrder(context.Background(), "ok"); err != nil || !found {
+		t.Fatalf("order is not cached: %v %v", found, err)
+	}
+
+	if acked := src.Acked(); len(acked) != 1 {
+		t.Fatalf("expected 1 ack, got %d", len(acked))
+	}
+	invalid, transient := 0, 0
+	for _, n := range src.Nacked() {
+		if errors.Is(n.Reason, ErrInvalid) {
+			invalid++
+		} else {
+			transient++
+		}
+	}
+	if invalid != 2 || transient != 1 {
+		t.Fatalf("expected 2 invalid and 1 transient nacks, got %d and %d", invalid, transient)
+	}
+}
+
+func TestReader_BatchesOrders(t *testing.T) {
+	repo := storagetest.NewRepo()
+
+	in := make(chan []byte, 3)
+	for _, uid := range []string{"a", "b", "c"} {
+		in <- orderJSON(t, uid)
+	}
+	close(in)
+	src := NewChanSource(in)
+
+	runReader(t, src, Options{Concurrency: 1, BatchSize: 10, BatchTimeout: time.Second}, repo)
+
+	if repo.Batches() != 1 || repo.Len() != 3 {
+		t.Fatalf("expected one batch of 3 orders, got %d batches and %d orders", repo.Batches(), repo.Len())
+	}
+	if acked := src.Acked(); len(acked) != 3 {
+		t.Fatalf("expected 3 acks, got %d", len(acked))
+	}
+}
+
+func TestFileSource_NDJSON(t *testing.T) {
+	data := string(orderJSON(t, "a")) + "\n\n" + string(orderJSON(t, "b")) + "\n"
+	src := NewNDJSONSource("orders.ndjson", io.NopCloser(strings.NewReader(data)))
+
+	ctx := context.Background()
+	m, err := src.Fetch(ctx)
+	if err != nil || m.Source != "orders.ndjson:1" {
+		t.Fatalf("first fetch: %q %v", m.Source, err)
+	}
+	m, err = src.Fetch(ctx)
+	if err != nil || m.Source != "orders.ndjson:3" {
+		t.Fatalf("second fetch must skip the blank line: %q %v", m.Source, err)
+	}
+	if _, err := src.Fetch(ctx); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+
+	repo := storagetest.NewRepo()
+	runReader(t, NewNDJSONSource("orders.ndjson", io.NopCloser(strings.NewReader(data))), Options{}, repo)
+	if repo.Len() != 2 {
+		t.Fatalf("expected 2 orders from file, got %d", repo.Len())
+	}
+}
+
+func TestReader_SkipsDuplicates(t *testing.T) {
+	repo := storagetest.NewRepo()
+	before := duplicates.Value()
+
+	// то же содержимое на другой строке (A→B→A) — новое сообщение
+	data := string(orderJSON(t, "a")) + "\n" + string(orderJSON(t, "b")) + "\n" + string(orderJSON(t, "a")) + "\n"
+	runReader(t, NewNDJSONSource("orders.ndjson", io.NopCloser(strings.NewReader(data))), Options{Concurrency: 1}, repo)
+	if got := duplicates.Value() - before; got != 0 {
+		t.Fatalf("A→B→A must not be deduplicated, got %d duplicates", got)
+	}
+
+	// повторный прогон того же файла — дубликаты целиком
+	runReader(t, NewNDJSONSource("orders.ndjson", io.NopCloser(strings.NewReader(data))), Options{Concurrency: 1}, repo)
+	if got := duplicates.Value() - before; got != 3 {
+		t.Fatalf("expected 3 duplicates on rerun, got %d", got)
+	}
+
+	for run := range 2 {
+		in := make(chan []byte, 1)
+		in <- orderJSON(t, "c")
+		close(in)
+		ch := NewChanSource(in)
+		runReader(t, ch, Options{Concurrency: 1}, repo)
+		if acked := ch.Acked(); len(acked) != 1 {
+			t.Fatalf("run %d: duplicates must be acked too, got %d acks", run, len(acked))
+		}
+	}
+	if got := duplicates.Value() - before; got != 4 {
+		t.Fatalf("expected the replayed channel message to be a duplicate, got %d in total", got)
+	}
+}
+
+func TestReader_SkipsStale(t *testing.T) {
+	repo := storagetest.NewRepo()
+	repo.Stale["old"] = true
+	before := stale.Value()
+
+	in := make(chan []byte, 2)
+	in <- orderJSON(t, "old")
+	in <- orderJSON(t, "fresh")
+	close(in)
+	src := NewChanSource(in)
+
+	c := runReader(t, src, Options{Concurrency: 1, BatchSize: 10, BatchTimeout: time.Second}, repo)
+
+	if got := stale.Value() - before; got != 1 {
+		t.Fatalf("expected 1 stale, got %d", got)
+	}
+	if acked := src.Acked(); len(acked) != 2 {
+		t.Fatalf("stale messages must be acked, got %d acks", len(acked))
+	}
+	if _, found, _ := c.GetOrder(context.Background(), "old"); found {
+		t.Fatalf("stale order must not reach the cache")
+	}
+}
+
+// versionedSource проставляет всем сообщениям ChanSource одну версию.
+type versionedSource struct {
+	*ChanSource
+	version int64
+}
+
+func (s versionedSource) Fetch(ctx context.Context) (Message, error) {
+	m, err := s.ChanSource.Fetch(ctx)
+	m.Version = s.version
+	return m, err
+}
+
+func TestReader_RejectsFutureVersion(t *testing.T) {
+	repo := storagetest.NewRepo()
+
+	in := make(chan []byte, 1)
+	in <- orderJSON(t, "future")
+	close(in)
+	src := NewChanSource(in)
+
+	future := time.Now().Add(time.Hour).UnixMicro()
+	runReader(t, versionedSource{ChanSource: src, version: future}, Options{Concurrency: 1}, repo)
+
+	if _, ok := repo.Order("future"); ok {
+		t.Fatalf("order with a version from the future must not be stored")
+	}
+	if nacked := src.Nacked(); len(nacked) != 1 || !errors.Is(nacked[0].Reason, ErrInvalid) {
+		t.Fatalf("expected one invalid nack, got %+v", nacked)
+	}
+}
@@ -0,0 +1,120 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	headerRetryStage     = "x-retry-stage"
+	headerRetryAttempt   = "x-retry-attempt"
+	headerRetryNotBefore = "x-retry-not-before"
+)
+
+type RetryStage struct {
+	Topic string
+	Delay time.Duration
+}
+
+// ParseRetryStages разбирает строку вида "orders.retry.5s=5s,orders.retry.1m=1m";
+// нужна хотя бы одна ступень.
+func ParseRetryStages(s string) ([]RetryStage, error) {
+	var stages []RetryStage
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		topic, delay, ok := strings.Cut(part, "=")
+		if !ok || strings.TrimSpace(topic) == "" {
+			return nil, fmt.Errorf("bad retry stage %q: want topic=delay", part)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(delay))
+		if err != nil {
+			return nil, fmt.Errorf("bad retry stage %q: %w", part, err)
+		}
+		stages = append(stages, RetryStage{Topic: strings.TrimSpace(topic), Delay: d})
+	}
+	if len(stages) == 0 {
+		return nil, errors.New("no retry stages: want at least one topic=delay")
+	}
+	return stages, nil
+}
+
+// retry перекладывает сообщение на следующую ступень повторов (или в DLQ после последней)
+// и коммитит его в текущем топике. Без настроенных ступеней сообщение сразу уходит
+// в DLQ: незакоммиченный оффсет остановил бы коммиты всей партиции.
+func (s *KafkaSource) retry(ctx context.Context, st *stream, m kafka.Message, reason error) error {
+	next := st.stage + 1
+	if next >= len(s.cfg.RetryStages) {
+		return s.reject(ctx, st, m, fmt.Errorf("retries exhausted: %w", reason))
+	}
+
+	stage := s.cfg.RetryStages[next]
+	msg := retryMessage(stage, next, m, reason, time.Now())
+	if err := s.publish(ctx, msg); err != nil {
+		return err
+	}
+	log.Printf("message %s/%d/%d scheduled to %s in %s: %v", m.Topic, m.Partition, m.Offset, stage.Topic, stage.Delay, reason)
+	return st.commit(ctx, []kafka.Message{m})
+}
+
+func retryMessage(st RetryStage, stage int, m kafka.Message, reason error, at time.Time) kafka.Message {
+	attempt := 1
+	if v, ok := header(m, headerRetryAttempt); ok {
+		if n, err := strconv.Atoi(v); err == nil {
+			attempt = n + 1
+		}
+	}
+
+	headers := withSource(m, headerError, headerFailedAt, headerRetryStage, headerRetryAttempt, headerRetryNotBefore)
+	headers = append(headers,
+		kafka.Header{Key: headerError, Value: []byte(reason.Error())},
+		kafka.Header{Key: headerFailedAt, Value: []byte(at.UTC().Format(time.RFC3339Nano))},
+		kafka.Header{Key: headerRetryStage, Value: []byte(strconv.Itoa(stage))},
+		kafka.Header{Key: headerRetryAttempt, Value: []byte(strconv.Itoa(attempt))},
+		kafka.Header{Key: headerRetryNotBefore, Value: []byte(at.Add(st.Delay).UTC().Format(time.RFC3339Nano))},
+	)
+	return kafka.Message{
+		Topic:   st.Topic,
+		Key:     m.Key,
+		Value:   m.Value,
+		Headers: headers,
+		Time:    m.Time,
+	}
+}
+
+func notBefore(m kafka.Message) time.Time {
+	v, ok := header(m, headerRetryNotBefore)
+	if !ok {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// waitUntil ждёт наступления t; false, если ctx отменён раньше.
+func waitUntil(ctx context.Context, t time.Time) bool {
+	d := time.Until(t)
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,79 @@
+package consumer
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestParseRetryStages(t *testing.T) {
+	stages, err := ParseRetryStages(" orders.retry.5s=5s, orders.retry.1m=1m ,")
+	if err != nil {
+		t.Fatalf("parse err: %v", err)
+	}
+	want := []RetryStage{{Topic: "orders.retry.5s", Delay: 5 * time.Second}, {Topic: "orders.retry.1m", Delay: time.Minute}}
+	if len(stages) != len(want) {
+		t.Fatalf("expected %d stages, got %+v", len(want), stages)
+	}
+	for i := range want {
+		if stages[i] != want[i] {
+			t.Fatalf("stage %d = %+v, want %+v", i, stages[i], want[i])
+		}
+	}
+
+	if _, err := ParseRetryStages("orders.retry=soon"); err == nil {
+		t.Fatalf("expected error for bad delay")
+	}
+	if _, err := ParseRetryStages("=5s"); err == nil {
+		t.Fatalf("expected error for empty topic")
+	}
+	if _, err := ParseRetryStages(" , "); err == nil {
+		t.Fatalf("expected error for no stages")
+	}
+}
+
+func TestRetryMessage_Ladder(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	src := kafka.Message{Topic: "orders", Partition: 1, Offset: 7, Key: []byte("uid-1"), Value: []byte("{}"), Time: at.Add(-time.Hour)}
+
+	first := retryMessage(RetryStage{Topic: "orders.retry.5s", Delay: 5 * time.Second}, 0, src, errors.New("db down"), at)
+	if first.Topic != "orders.retry.5s" {
+		t.Fatalf("bad topic: %s", first.Topic)
+	}
+	if v, _ := header(first, headerRetryAttempt); v != "1" {
+		t.Fatalf("attempt = %q, want 1", v)
+	}
+	if got := notBefore(first); !got.Equal(at.Add(5 * time.Second)) {
+		t.Fatalf("not before = %v", got)
+	}
+
+	// сообщение прочитано из топика повторов: источник должен остаться исходным
+	first.Topic, first.Partition, first.Offset = "orders.retry.5s", 0, 3
+	second := retryMessage(RetryStage{Topic: "orders.retry.1m", Delay: time.Minute}, 1, first, errors.New("db still down"), at)
+	want := map[string]string{
+		headerError:          "db still down",
+		headerTopic:          "orders",
+		headerPartition:      "1",
+		headerOffset:         "7",
+		headerRetryStage:     "1",
+		headerRetryAttempt:   "2",
+		headerRetryNotBefore: "2024-01-02T03:05:05Z",
+	}
+	for k, v := range want {
+		if got, _ := header(second, k); got != v {
+			t.Fatalf("header %s = %q, want %q", k, got, v)
+		}
+	}
+	if orderVersion(second) != orderVersion(src) || originID(second) != "orders/1/7" {
+		t.Fatalf("retried message must keep version and origin: %d %s", orderVersion(second), originID(second))
+	}
+	seen := map[string]bool{}
+	for _, h := range second.Headers {
+		if seen[h.Key] {
+			t.Fatalf("duplicate header %s", h.Key)
+		}
+		seen[h.Key] = true
+	}
+}
@@ -0,0 +1,38 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrInvalid помечает причину Nack, при которой повтор бесполезен: сообщение
+// не разбирается или не проходит валидацию.
+var ErrInvalid = errors.New("invalid message")
+
+// OrderSource — источник сообщений с заказами. Fetch блокируется до следующего
+// сообщения и возвращает io.EOF, когда источник исчерпан. Каждое полученное
+// сообщение должно быть подтверждено через Ack или отклонено через Nack.
+type OrderSource interface {
+	Fetch(ctx context.Context) (Message, error)
+	Ack(ctx context.Context, msgs ...Message) error
+	Nack(ctx context.Context, m Message, reason error) error
+	Close() error
+}
+
+type Message struct {
+	Key   []byte
+	Value []byte
+	// ID — устойчивый идентификатор сообщения для дедупликации. Пустой — дедупликация по содержимому.
+	ID string
+	// Version — версия заказа в сообщении (например, время события в микросекундах); 0 — неизвестна.
+	Version int64
+	// Kind — тип источника: kafka, file, chan.
+	Kind string
+	// Source — откуда пришло сообщение, для логов и истории: topic/partition/offset, file:line и т.п.
+	Source     string
+	ReceivedAt time.Time
+
+	// ref — данные конкретного источника, нужные для Ack/Nack
+	ref any
+}
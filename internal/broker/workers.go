@@ -0,0 +1,106 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// workerPool раскладывает сообщения по воркерам по ключу заказа, поэтому
+// апсерты одного order_uid выполняются строго по порядку.
+type workerPool struct {
+	queues []chan Message
+	wg     sync.WaitGroup
+}
+
+func (c *Reader) startWorkers(ctx context.Context) *workerPool {
+	p := &workerPool{queues: make([]chan Message, c.opts.Concurrency)}
+	for i := range p.queues {
+		q := make(chan Message, 16)
+		p.queues[i] = q
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			c.work(ctx, q)
+		}()
+	}
+	return p
+}
+
+// work копит сообщения очереди в пачки по BatchSize штук или BatchTimeout.
+func (c *Reader) work(ctx context.Context, q <-chan Message) {
+	batch := make([]Message, 0, c.opts.BatchSize)
+	flush := func() {
+		c.handleBatch(ctx, batch)
+		batch = make([]Message, 0, c.opts.BatchSize)
+	}
+
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		if len(batch) == 0 {
+			m, ok := <-q
+			if !ok {
+				return
+			}
+			batch = append(batch, m)
+			if len(batch) >= c.opts.BatchSize {
+				flush()
+				continue
+			}
+			timer.Reset(c.opts.BatchTimeout)
+		}
+
+		select {
+		case m, ok := <-q:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, m)
+			if len(batch) >= c.opts.BatchSize {
+				timer.Stop()
+				flush()
+			}
+		case <-timer.C:
+			flush()
+		}
+	}
+}
+
+func (p *workerPool) dispatch(ctx context.Context, m Message) bool {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(orderKey(m)))
+	select {
+	case p.queues[h.Sum32()%uint32(len(p.queues))] <- m:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (p *workerPool) stop() {
+	for _, q := range p.queues {
+		close(q)
+	}
+	p.wg.Wait()
+}
+
+// orderKey достаёт order_uid из тела; для неразбираемых сообщений порядок
+// не важен, и ключом служит ключ сообщения или его источник.
+func orderKey(m Message) string {
+	var k struct {
+		OrderUID string `json:"order_uid"`
+	}
+	if err := json.Unmarshal(m.Value, &k); err == nil && k.OrderUID != "" {
+		return k.OrderUID
+	}
+	if len(m.Key) > 0 {
+		return string(m.Key)
+	}
+	return m.Source
+}
@@ -0,0 +1,50 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/CodenSell/WB_test_level0/internal/storage/storagetest"
+	"github.com/CodenSell/WB_test_level0/internal/structs"
+)
+
+// BenchmarkCacheParallel сравнивает одну блокировку (shards=1) с шардированным
+// кэшем при параллельных чтениях с долей записей.
+//
+//	go test -bench CacheParallel -cpu 1,4,16 ./internal/cache
+func BenchmarkCacheParallel(b *testing.B) {
+	const orders = 10000
+	uids := make([]string, orders)
+	for i := range uids {
+		uids[i] = fmt.Sprintf("order-%d", i)
+	}
+
+	for _, shards := range []int{1, 16, 64} {
+		for _, writePct := range []int{0, 10, 50} {
+			b.Run(fmt.Sprintf("shards=%d/writes=%d%%", shards, writePct), func(b *testing.B) {
+				c := NewCache(storagetest.NewRepo(), "", Options{Shards: shards})
+				ctx := context.Background()
+				for _, uid := range uids {
+					c.SetOrder(ctx, &structs.Order{OrderUID: uid})
+				}
+
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					r := rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+					for pb.Next() {
+						uid := uids[r.IntN(orders)]
+						if r.IntN(100) < writePct {
+							c.SetOrder(ctx, &structs.Order{OrderUID: uid})
+							continue
+						}
+						if _, found, _ := c.GetOrder(ctx, uid); !found {
+							b.Fatalf("%s not found", uid)
+						}
+					}
+				})
+			})
+		}
+	}
+}
@@ -6,54 +6,148 @@ import (
 	"errors"
 	"log"
 	"os"
+	"slices"
 	"strings"
-	"sync"
+	"sync/atomic"
+	"time"
 
 	"database/sql"
 
-	"github.com/CodenSell/WB_test_level0/internal/storage/postgres"
+	"github.com/CodenSell/WB_test_level0/internal/storage"
 	"github.com/CodenSell/WB_test_level0/internal/structs"
 	"github.com/CodenSell/WB_test_level0/internal/validation"
+	"golang.org/x/sync/singleflight"
 )
 
-type Cache struct {
-	mu    sync.RWMutex
-	cache map[string]structs.Order
-	repo  *postgres.Repository
+// Options ограничивают размер кэша и срок жизни записей; нулевое значение —
+// без ограничения.
+type Options struct {
+	MaxEntries int
+	// MaxBytes — примерный объём заказов в памяти, см. orderSize.
+	MaxBytes int64
+	// TTL — сколько запись живёт после загрузки; по истечении заказ читается из БД заново.
+	TTL time.Duration
+	// RefreshAhead — за сколько до истечения TTL попадание запускает фоновую
+	// перезагрузку заказа, чтобы горячие записи не истекали под запросами.
+	RefreshAhead time.Duration
+	// NegativeTTL — сколько помнить, что заказа с таким uid нет в БД; 0 — не помнить.
+	// NegativeMaxEntries ограничивает число таких записей.
+	NegativeTTL        time.Duration
+	NegativeMaxEntries int
+	// IndexMaxEntries — сколько значений вторичных ключей (трек-номер,
+	// транзакция, rid, покупатель) помнит Lookup; срок жизни у них тот же TTL.
+	IndexMaxEntries int
+	// Shards — на сколько частей с отдельными блокировками делится кэш;
+	// лимиты делятся между ними так, что в сумме дают общий. 0 или 1 — одна
+	// общая блокировка; шардов не бывает больше MaxEntries. Заказы ложатся в
+	// шарды по хэшу неравномерно, поэтому шард может начать вытеснять раньше,
+	// чем кэш наберёт общий лимит.
+	Shards int
+	// LoadTimeout ограничивает чтение заказа или индекса из БД при промахе и
+	// фоновую перезагрузку; 0 — defaultLoadTimeout.
+	LoadTimeout time.Duration
+	// WarmWorkers и WarmPageSize — сколько параллельных загрузчиков и по сколько
+	// заказов за запрос читает Warm.
+	WarmWorkers  int
+	WarmPageSize int
+	// OnChange вызывается после записи заказа через CreateOrder или SetOrder —
+	// например, чтобы оповестить другие экземпляры сервиса.
+	OnChange func(uid string)
 }
 
-func NewCache(repo *postgres.Repository, path string) *Cache {
-	cache := &Cache{
-		repo:  repo,
-		cache: make(map[string]structs.Order),
-	}
-	cache.readAndLoadFromFile(path)
+// Stats — счётчики кэша для /debug/vars.
+type Stats struct {
+	Entries   int   `json:"entries"`
+	Bytes     int64 `json:"bytes"`
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+	Expired   int64 `json:"expired"`
+	Refreshes int64 `json:"refreshes"`
+	// Loads — чтения из БД при промахах; одновременные промахи по одному uid
+	// дают одно чтение.
+	Loads int64 `json:"loads"`
+	// Negative — сколько uid сейчас помечены как отсутствующие, NegativeHits —
+	// сколько запросов ими отсечено.
+	Negative     int   `json:"negative"`
+	NegativeHits int64 `json:"negative_hits"`
+	// IndexEntries — загруженные значения вторичных ключей; IndexHits и
+	// IndexLoads — поиски по ним из памяти и с чтением из БД.
+	IndexEntries int   `json:"index_entries"`
+	IndexHits    int64 `json:"index_hits"`
+	IndexLoads   int64 `json:"index_loads"`
+}
 
-	ctx := context.Background()
-	cache.fillUpCache(ctx)
+// OrderCache — кэш заказов перед БД: промахи догружаются из репозитория,
+// запись идёт в БД и затем в кэш.
+type OrderCache interface {
+	GetOrder(ctx context.Context, uid string) (*structs.Order, bool, error)
+	// CreateOrder сохраняет заказ в БД и кэше; created — заказ с таким uid появился впервые.
+	CreateOrder(ctx context.Context, o *structs.Order, meta storage.Meta) (created bool, err error)
+	// SetOrder кладёт в кэш заказ, который уже сохранён в БД.
+	SetOrder(ctx context.Context, o *structs.Order)
+	// Invalidate убирает заказ из кэша; cached — заказ там был.
+	Invalidate(ctx context.Context, uid string) (cached bool)
+	// Reload перечитывает из БД заказ, если он есть в кэше.
+	Reload(ctx context.Context, uid string) error
+	// Lookup ищет заказы по вторичному ключу, сначала новые; нет заказов — пустой список.
+	Lookup(ctx context.Context, key storage.LookupKey, value string) ([]structs.Order, error)
+	Stats() Stats
 
-	return cache
+	// UIDs — закэшированные uid больше after по возрастанию, не больше limit.
+	UIDs(ctx context.Context, after string, limit int) ([]string, error)
+	// Flush очищает кэш целиком.
+	Flush(ctx context.Context) error
+	// Warm заполняет кэш заказами из БД и возвращает их число.
+	Warm(ctx context.Context) (int, error)
 }
 
-func (a *Cache) fillUpCache(ctx context.Context) {
-	uids, err := a.repo.ListOrderUIDs(ctx)
-	if err != nil {
-		log.Printf("cache preload error: %v", err)
-		return
+var (
+	_ OrderCache = (*Cache)(nil)
+	_ OrderCache = (*RedisCache)(nil)
+)
+
+// Cache держит недавно использованные заказы; при переполнении вытесняются
+// давно не запрошенные, а промахи догружаются из БД.
+type Cache struct {
+	shards []*shard
+	repo   storage.OrderRepo
+	opts   Options
+	now    func() time.Time
+	loads  singleflight.Group
+	// lookups схлопывает одновременные чтения вторичного индекса из БД.
+	lookups singleflight.Group
+
+	// total — записи и объём по всем шардам, для full без блокировок.
+	total usage
+
+	hits, misses, evictions, expired, refreshes, loaded, negativeHits atomic.Int64
+
+	indexHits, indexLoads atomic.Int64
+}
+
+// defaultLoadTimeout — срок чтения из БД при промахе, если LoadTimeout не задан.
+const defaultLoadTimeout = 5 * time.Second
+
+// detach отвязывает чтение из БД от отмены запроса, который его начал (его
+// результат ждут и другие запросы), но ограничивает его timeout: зависшая БД
+// не должна вечно держать ожидающих и фоновые перезагрузки.
+func detach(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), timeout)
+}
+
+func NewCache(repo storage.OrderRepo, path string, opts Options) *Cache {
+	if opts.LoadTimeout <= 0 {
+		opts.LoadTimeout = defaultLoadTimeout
 	}
-	count := 0
-	for _, uid := range uids {
-		o, err := a.repo.GetOrder(ctx, uid)
-		if err != nil {
-			log.Printf("preload get %s: %v", uid, err)
-			continue
-		}
-		a.mu.Lock()
-		a.cache[o.OrderUID] = *o
-		a.mu.Unlock()
-		count++
+	cache := &Cache{
+		repo: repo,
+		opts: opts,
+		now:  time.Now,
 	}
-	log.Printf("cache preload done, loaded %d orders", count)
+	cache.shards = newShards(opts, &cache.total)
+	cache.readAndLoadFromFile(path)
+	return cache
 }
 
 func (a *Cache) readAndLoadFromFile(path string) {
@@ -71,53 +165,294 @@ func (a *Cache) readAndLoadFromFile(path string) {
 		log.Printf("skip preload invalid model.json: %v", err)
 		return
 	}
-	a.mu.Lock()
-	a.cache[o.OrderUID] = o
-	a.mu.Unlock()
+	a.put(o)
 	log.Printf("loaded order %s", o.OrderUID)
 }
 
-func (a *Cache) CreateOrder(ctx context.Context, o *structs.Order) error {
+// CreateOrder сохраняет заказ в БД и кэше; created — заказ с таким uid появился впервые.
+func (a *Cache) CreateOrder(ctx context.Context, o *structs.Order, meta storage.Meta) (bool, error) {
 	if o == nil {
-		return errors.New("nil order")
+		return false, errors.New("nil order")
 	}
 	uid := strings.TrimSpace(o.OrderUID)
 	if uid == "" {
-		return errors.New("empty order_uid")
+		return false, errors.New("empty order_uid")
 	}
-	if err := a.repo.UpsertOrder(ctx, o); err != nil {
+	created, err := a.repo.UpsertOrder(ctx, o, meta)
+	if err != nil {
+		return false, err
+	}
+	a.put(*o)
+	a.changed(uid)
+	return created, nil
+}
+
+// SetOrder кладёт в кэш заказ, который уже сохранён в БД.
+func (a *Cache) SetOrder(_ context.Context, o *structs.Order) {
+	a.put(*o)
+	a.changed(o.OrderUID)
+}
+
+// Invalidate убирает заказ и отметку об его отсутствии из кэша; cached —
+// заказ был в кэше. В отличие от записи, OnChange не вызывается.
+func (a *Cache) Invalidate(_ context.Context, uid string) (cached bool) {
+	s := a.shard(uid)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.missing.remove(uid)
+	_, cached = s.cache.items[uid]
+	s.cache.remove(uid)
+	return cached
+}
+
+// Reload перечитывает из БД заказ, который есть в кэше; остальные только
+// снимают отметку об отсутствии. Если чтение не удалось, заказ вытесняется.
+func (a *Cache) Reload(ctx context.Context, uid string) error {
+	s := a.shard(uid)
+	s.mu.Lock()
+	s.missing.remove(uid)
+	_, cached := s.cache.items[uid]
+	s.mu.Unlock()
+	if !cached {
+		return nil
+	}
+
+	o, err := a.repo.GetOrder(ctx, uid)
+	if err != nil {
+		a.Invalidate(ctx, uid)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
 		return err
 	}
-	a.mu.Lock()
-	a.cache[uid] = *o
-	a.mu.Unlock()
+	a.put(*o)
+	return nil
+}
+
+func (a *Cache) UIDs(_ context.Context, after string, limit int) ([]string, error) {
+	var uids []string
+	for _, s := range a.shards {
+		s.mu.Lock()
+		for uid := range s.cache.items {
+			if uid > after {
+				uids = append(uids, uid)
+			}
+		}
+		s.mu.Unlock()
+	}
+	slices.Sort(uids)
+	if limit > 0 && len(uids) > limit {
+		uids = uids[:limit]
+	}
+	return uids, nil
+}
+
+func (a *Cache) Flush(context.Context) error {
+	for _, s := range a.shards {
+		s.mu.Lock()
+		s.cache.clear()
+		s.missing = newNegative(s.missing.max)
+		s.keys = newIndex(s.keys.max)
+		s.mu.Unlock()
+	}
 	return nil
 }
 
+func (a *Cache) changed(uid string) {
+	if a.opts.OnChange != nil {
+		a.opts.OnChange(uid)
+	}
+}
+
 func (a *Cache) GetOrder(ctx context.Context, uid string) (*structs.Order, bool, error) {
 	uid = strings.TrimSpace(uid)
 	if uid == "" {
 		return nil, false, errors.New("empty uid")
 	}
 
-	a.mu.RLock()
-	if o, ok := a.cache[uid]; ok {
-		a.mu.RUnlock()
+	if o, ok := a.lookup(uid); ok {
+		a.hits.Add(1)
+		return o, true, nil
+	}
+	a.misses.Add(1)
+
+	s := a.shard(uid)
+	s.mu.Lock()
+	missing := s.missing.has(uid, a.now())
+	s.mu.Unlock()
+	if missing {
+		a.negativeHits.Add(1)
+		return nil, false, nil
+	}
+
+	// одновременные промахи по одному uid ждут одно чтение из БД; отмена
+	// запроса, который начал чтение, не должна обрывать его для остальных
+	ch := a.loads.DoChan(uid, func() (any, error) {
+		ctx, cancel := detach(ctx, a.opts.LoadTimeout)
+		defer cancel()
+		return a.load(ctx, uid)
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			if errors.Is(res.Err, sql.ErrNoRows) {
+				return nil, false, nil
+			}
+			return nil, false, res.Err
+		}
+		o := *res.Val.(*structs.Order)
 		return &o, true, nil
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
 	}
-	a.mu.RUnlock()
+}
 
+func (a *Cache) load(ctx context.Context, uid string) (*structs.Order, error) {
+	a.loaded.Add(1)
 	o, err := a.repo.GetOrder(ctx, uid)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, false, nil
+	if errors.Is(err, sql.ErrNoRows) && a.opts.NegativeTTL > 0 {
+		s := a.shard(uid)
+		s.mu.Lock()
+		// заказ мог появиться, пока шло чтение
+		if _, ok := s.cache.items[uid]; !ok {
+			s.missing.add(uid, a.now().Add(a.opts.NegativeTTL))
 		}
-		return nil, false, err
+		s.mu.Unlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+	a.put(*o)
+	return o, nil
+}
+
+// lookup возвращает заказ из кэша. Истёкшая запись удаляется и считается
+// промахом; запись, близкая к истечению, перечитывается в фоне.
+func (a *Cache) lookup(uid string) (*structs.Order, bool) {
+	now := a.now()
+
+	s := a.shard(uid)
+	s.mu.Lock()
+	e, ok := s.cache.get(uid)
+	if !ok {
+		s.mu.Unlock()
+		return nil, false
+	}
+	if !e.expires.IsZero() && !now.Before(e.expires) {
+		s.cache.remove(uid)
+		s.mu.Unlock()
+		a.expired.Add(1)
+		return nil, false
+	}
+	refresh := a.opts.RefreshAhead > 0 && !e.expires.IsZero() && !e.refreshing &&
+		now.After(e.expires.Add(-a.opts.RefreshAhead))
+	if refresh {
+		e.refreshing = true
+	}
+	o := e.order
+	s.mu.Unlock()
+
+	if refresh {
+		go a.refresh(uid)
+	}
+	return &o, true
+}
+
+func (a *Cache) refresh(uid string) {
+	a.refreshes.Add(1)
+	ctx, cancel := detach(context.Background(), a.opts.LoadTimeout)
+	defer cancel()
+	o, err := a.repo.GetOrder(ctx, uid)
+	if err == nil {
+		a.put(*o)
+		return
+	}
+	s := a.shard(uid)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if errors.Is(err, sql.ErrNoRows) {
+		s.cache.remove(uid)
+		return
+	}
+	log.Printf("cache refresh %s: %v", uid, err)
+	if e, ok := s.cache.get(uid); ok {
+		e.refreshing = false
 	}
+}
+
+func (a *Cache) Stats() Stats {
+	var entries, missing, keys int
+	var bytes int64
+	for _, s := range a.shards {
+		s.mu.Lock()
+		entries += s.cache.len()
+		bytes += s.cache.bytes
+		missing += s.missing.len()
+		keys += s.keys.len()
+		s.mu.Unlock()
+	}
+	return Stats{
+		Entries:   entries,
+		Bytes:     bytes,
+		Hits:      a.hits.Load(),
+		Misses:    a.misses.Load(),
+		Evictions: a.evictions.Load(),
+		Expired:   a.expired.Load(),
+		Refreshes: a.refreshes.Load(),
+		Loads:     a.loaded.Load(),
 
-	a.mu.Lock()
-	a.cache[uid] = *o
-	a.mu.Unlock()
+		Negative:     missing,
+		NegativeHits: a.negativeHits.Load(),
+
+		IndexEntries: keys,
+		IndexHits:    a.indexHits.Load(),
+		IndexLoads:   a.indexLoads.Load(),
+	}
+}
+
+// expiry — срок записи, загруженной сейчас; нулевое время — бессрочно.
+func (a *Cache) expiry() time.Time {
+	if a.opts.TTL > 0 {
+		return a.now().Add(a.opts.TTL)
+	}
+	return time.Time{}
+}
+
+func (a *Cache) put(o structs.Order) {
+	a.store(o, false)
+}
+
+// fill кладёт заказ, только если в его шарде есть место, ничего не вытесняя;
+// false — шард заполнен. Так прогрев не выталкивает из горячего шарда заказы,
+// которые сам же и загрузил.
+func (a *Cache) fill(o structs.Order) bool {
+	return a.store(o, true)
+}
+
+func (a *Cache) store(o structs.Order, onlyIfRoom bool) bool {
+	expires := a.expiry()
+	s := a.shard(o.OrderUID)
+	s.mu.Lock()
+	var old *structs.Order
+	if e, ok := s.cache.items[o.OrderUID]; ok {
+		prev := e.Value.(*entry).order
+		old = &prev
+	} else if onlyIfRoom && !s.cache.room(orderSize(&o)) {
+		s.mu.Unlock()
+		return false
+	}
+	s.missing.remove(o.OrderUID)
+	evicted := s.cache.add(o, expires)
+	s.mu.Unlock()
+	if evicted > 0 {
+		a.evictions.Add(int64(evicted))
+	}
+	a.reindex(old, &o)
+	return true
+}
 
-	return o, true, nil
+// full — кэш набрал общий лимит по числу записей или объёму.
+func (a *Cache) full() bool {
+	return (a.opts.MaxEntries > 0 && a.total.entries.Load() >= int64(a.opts.MaxEntries)) ||
+		(a.opts.MaxBytes > 0 && a.total.bytes.Load() >= a.opts.MaxBytes)
 }
@@ -0,0 +1,543 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CodenSell/WB_test_level0/internal/storage"
+	"github.com/CodenSell/WB_test_level0/internal/storage/storagetest"
+	"github.com/CodenSell/WB_test_level0/internal/structs"
+)
+
+func TestCache_WarmStopsAtCap(t *testing.T) {
+	repo := storagetest.NewRepo("a", "b", "c", "d", "e")
+	c := NewCache(repo, "", Options{MaxEntries: 2, WarmPageSize: 1})
+
+	n, err := c.Warm(context.Background())
+	if err != nil || n != 2 {
+		t.Fatalf("Warm = %d, %v; want 2 orders", n, err)
+	}
+	if st := c.Stats(); st.Entries != 2 || st.Evictions != 0 {
+		t.Fatalf("unexpected stats after warm-up: %+v", st)
+	}
+}
+
+func TestCache_WarmStopsAtCapMidPage(t *testing.T) {
+	uids := make([]string, 5000)
+	for i := range uids {
+		uids[i] = fmt.Sprintf("o%d", i)
+	}
+	repo := storagetest.NewRepo(uids...)
+	repo.Delay = 5 * time.Millisecond
+	c := NewCache(repo, "", Options{MaxEntries: 100, WarmPageSize: 500, WarmWorkers: 2})
+
+	type result struct {
+		n   int
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		n, err := c.Warm(context.Background())
+		done <- result{n, err}
+	}()
+	select {
+	case res := <-done:
+		// два загрузчика могут одновременно положить последний заказ
+		if res.err != nil || res.n < 100 || c.Stats().Entries != 100 {
+			t.Fatalf("Warm = %d, %v; want the cache filled to 100 orders", res.n, res.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Warm hangs when the database holds more orders than the cache cap")
+	}
+}
+
+func TestCache_WarmParallel(t *testing.T) {
+	uids := make([]string, 1000)
+	for i := range uids {
+		uids[i] = fmt.Sprintf("o%d", i)
+	}
+	repo := storagetest.NewRepo(uids...)
+	c := NewCache(repo, "", Options{Shards: 4, WarmWorkers: 4, WarmPageSize: 64})
+
+	n, err := c.Warm(context.Background())
+	if err != nil || n != len(uids) {
+		t.Fatalf("Warm = %d, %v; want %d orders", n, err, len(uids))
+	}
+	before := repo.Gets()
+	for _, uid := range uids {
+		if _, found, _ := c.GetOrder(context.Background(), uid); !found {
+			t.Fatalf("%s not found", uid)
+		}
+	}
+	if repo.Gets() != before {
+		t.Fatal("warmed orders were read from the repo again")
+	}
+}
+
+func TestCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewCache(storagetest.NewRepo(), "", Options{MaxEntries: 2})
+	ctx := context.Background()
+
+	c.SetOrder(context.Background(), &structs.Order{OrderUID: "a"})
+	c.SetOrder(context.Background(), &structs.Order{OrderUID: "b"})
+	if _, found, _ := c.GetOrder(ctx, "a"); !found {
+		t.Fatal("a not found")
+	}
+	c.SetOrder(context.Background(), &structs.Order{OrderUID: "c"})
+
+	s := c.shards[0]
+	s.mu.Lock()
+	_, hasA := s.cache.items["a"]
+	_, hasB := s.cache.items["b"]
+	s.mu.Unlock()
+	if !hasA || hasB {
+		t.Fatalf("expected b to be evicted, has a=%v b=%v", hasA, hasB)
+	}
+	if st := c.Stats(); st.Entries != 2 || st.Evictions != 1 || st.Hits != 1 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestCache_EvictsByBytes(t *testing.T) {
+	size := orderSize(&structs.Order{OrderUID: "a"})
+	c := NewCache(storagetest.NewRepo(), "", Options{MaxBytes: 2 * size})
+
+	for _, uid := range []string{"a", "b", "c"} {
+		c.SetOrder(context.Background(), &structs.Order{OrderUID: uid})
+	}
+	if st := c.Stats(); st.Entries != 2 || st.Bytes > 2*size || st.Evictions != 1 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestCache_MissFallsBackToRepo(t *testing.T) {
+	repo := storagetest.NewRepo()
+	c := NewCache(repo, "", Options{MaxEntries: 1})
+	repo.Put(structs.Order{OrderUID: "x"})
+
+	o, found, err := c.GetOrder(context.Background(), "x")
+	if err != nil || !found || o.OrderUID != "x" {
+		t.Fatalf("got %+v %v %v", o, found, err)
+	}
+	if _, found, _ := c.GetOrder(context.Background(), "missing"); found {
+		t.Fatal("missing order found")
+	}
+	if st := c.Stats(); st.Misses != 2 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestCache_TTLExpiry(t *testing.T) {
+	repo := storagetest.NewRepo()
+	c := NewCache(repo, "", Options{TTL: time.Minute})
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	c.SetOrder(context.Background(), &structs.Order{OrderUID: "a", TrackNumber: "cached"})
+	repo.Put(structs.Order{OrderUID: "a", TrackNumber: "db"})
+
+	o, _, _ := c.GetOrder(context.Background(), "a")
+	if o.TrackNumber != "cached" {
+		t.Fatalf("got %q before expiry", o.TrackNumber)
+	}
+
+	now = now.Add(time.Minute)
+	o, _, _ = c.GetOrder(context.Background(), "a")
+	if o.TrackNumber != "db" {
+		t.Fatalf("got %q after expiry, want reload from db", o.TrackNumber)
+	}
+	if st := c.Stats(); st.Expired != 1 || st.Misses != 1 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestCache_RefreshAhead(t *testing.T) {
+	repo := storagetest.NewRepo()
+	c := NewCache(repo, "", Options{TTL: time.Minute, RefreshAhead: 10 * time.Second})
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	c.SetOrder(context.Background(), &structs.Order{OrderUID: "a", TrackNumber: "cached"})
+	repo.Put(structs.Order{OrderUID: "a", TrackNumber: "db"})
+
+	now = now.Add(55 * time.Second)
+	o, _, _ := c.GetOrder(context.Background(), "a")
+	if o.TrackNumber != "cached" {
+		t.Fatalf("refresh-ahead must serve the cached order, got %q", o.TrackNumber)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		s := c.shard("a")
+		s.mu.Lock()
+		e, _ := s.cache.get("a")
+		track, expires := e.order.TrackNumber, e.expires
+		s.mu.Unlock()
+		if track == "db" {
+			if !expires.Equal(now.Add(time.Minute)) {
+				t.Fatalf("refreshed entry expires at %v, want %v", expires, now.Add(time.Minute))
+			}
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("entry was not refreshed in background")
+}
+
+func TestCache_CoalescesConcurrentMisses(t *testing.T) {
+	repo := storagetest.NewRepo()
+	c := NewCache(repo, "", Options{})
+	repo.Put(structs.Order{OrderUID: "hot"})
+	repo.Block = make(chan struct{})
+
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			o, found, err := c.GetOrder(context.Background(), "hot")
+			if err == nil && (!found || o.OrderUID != "hot") {
+				err = errors.New("hot order not found")
+			}
+			errs <- err
+		}()
+	}
+	// даём всем горутинам дойти до ожидания общей загрузки
+	time.Sleep(50 * time.Millisecond)
+	close(repo.Block)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := repo.Gets(); got != 1 {
+		t.Fatalf("repo.GetOrder called %d times, want 1", got)
+	}
+	if st := c.Stats(); st.Loads != 1 || st.Misses != n {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestCache_MissRespectsCallerContext(t *testing.T) {
+	repo := storagetest.NewRepo()
+	c := NewCache(repo, "", Options{})
+	repo.Put(structs.Order{OrderUID: "slow"})
+	repo.Block = make(chan struct{})
+	defer close(repo.Block)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := c.GetOrder(ctx, "slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestCache_DetachedLoadTimesOut(t *testing.T) {
+	repo := storagetest.NewRepo()
+	c := NewCache(repo, "", Options{LoadTimeout: 20 * time.Millisecond})
+	repo.Put(structs.Order{OrderUID: "hung"})
+	repo.Block = make(chan struct{})
+	defer close(repo.Block)
+
+	// у запроса срока нет, общее чтение обрывает LoadTimeout
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := c.GetOrder(context.Background(), "hung")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected deadline exceeded, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("load from a hung DB did not time out")
+	}
+}
+
+func TestCache_NegativeCaching(t *testing.T) {
+	repo := storagetest.NewRepo()
+	c := NewCache(repo, "", Options{NegativeTTL: time.Minute, NegativeMaxEntries: 2})
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for range 3 {
+		if _, found, err := c.GetOrder(ctx, "ghost"); found || err != nil {
+			t.Fatalf("ghost: found=%v err=%v", found, err)
+		}
+	}
+	if got := repo.Gets(); got != 1 {
+		t.Fatalf("repo.GetOrder called %d times, want 1", got)
+	}
+
+	// CreateOrder снимает отметку
+	if _, err := c.CreateOrder(ctx, &structs.Order{OrderUID: "ghost"}, storage.Meta{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := c.GetOrder(ctx, "ghost"); !found {
+		t.Fatal("created order is still reported missing")
+	}
+
+	// запись истекает
+	_, _, _ = c.GetOrder(ctx, "gone")
+	now = now.Add(time.Minute)
+	repo.Put(structs.Order{OrderUID: "gone"})
+	if _, found, _ := c.GetOrder(ctx, "gone"); !found {
+		t.Fatal("negative entry did not expire")
+	}
+
+	// размер ограничен
+	for _, uid := range []string{"x1", "x2", "x3"} {
+		_, _, _ = c.GetOrder(ctx, uid)
+	}
+	if st := c.Stats(); st.Negative != 2 || st.NegativeHits != 2 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestCache_ShardedSmallCap(t *testing.T) {
+	for _, tc := range []struct{ shards, max int }{{16, 10}, {16, 100}, {3, 7}} {
+		c := NewCache(storagetest.NewRepo(), "", Options{Shards: tc.shards, MaxEntries: tc.max})
+		total := 0
+		for _, s := range c.shards {
+			total += s.cache.maxEntries
+		}
+		if total != tc.max || len(c.shards) > tc.max {
+			t.Fatalf("%+v: %d shards with %d entries in total", tc, len(c.shards), total)
+		}
+		for i := range 10 * tc.max {
+			c.SetOrder(context.Background(), &structs.Order{OrderUID: fmt.Sprintf("o%d", i)})
+		}
+		if st := c.Stats(); st.Entries != tc.max {
+			t.Fatalf("%+v: %d entries, want exactly the cap", tc, st.Entries)
+		}
+	}
+}
+
+func TestCache_WarmShardedNoEvictions(t *testing.T) {
+	uids := make([]string, 500)
+	for i := range uids {
+		uids[i] = fmt.Sprintf("o%d", i)
+	}
+	c := NewCache(storagetest.NewRepo(uids...), "", Options{Shards: 8, MaxEntries: 40, WarmPageSize: 50})
+
+	n, err := c.Warm(context.Background())
+	if err != nil || n != 40 {
+		t.Fatalf("Warm = %d, %v; want 40 orders", n, err)
+	}
+	if st := c.Stats(); st.Entries != 40 || st.Evictions != 0 {
+		t.Fatalf("warm-up must fill every shard without evictions: %+v", st)
+	}
+}
+
+func TestCache_Sharded(t *testing.T) {
+	c := NewCache(storagetest.NewRepo(), "", Options{Shards: 8, MaxEntries: 80})
+	if len(c.shards) != 8 || c.shards[0].cache.maxEntries != 10 {
+		t.Fatalf("bad shards: %d, per shard limit %d", len(c.shards), c.shards[0].cache.maxEntries)
+	}
+
+	for i := range 40 {
+		c.SetOrder(context.Background(), &structs.Order{OrderUID: fmt.Sprintf("o%d", i)})
+	}
+	for i := range 40 {
+		uid := fmt.Sprintf("o%d", i)
+		if _, found, _ := c.GetOrder(context.Background(), uid); !found {
+			t.Fatalf("%s not found", uid)
+		}
+	}
+
+	used := 0
+	for _, s := range c.shards {
+		if s.cache.len() > 0 {
+			used++
+		}
+	}
+	if used < 2 {
+		t.Fatalf("orders landed in %d shard(s)", used)
+	}
+	if st := c.Stats(); st.Entries != 40 || st.Hits != 40 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestCache_Snapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.gob")
+	repo := storagetest.NewRepo("a", "b")
+
+	c := NewCache(repo, "", Options{})
+	if _, err := c.Warm(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	n, err := c.SaveSnapshot(path)
+	if err != nil || n != 2 {
+		t.Fatalf("SaveSnapshot = %d, %v", n, err)
+	}
+
+	// после снимка заказ b изменился, а c появился
+	repo.Put(structs.Order{OrderUID: "b", TrackNumber: "changed"})
+	repo.Put(structs.Order{OrderUID: "c"})
+	repo.Changed = []string{"b", "c"}
+	gets := repo.Gets()
+
+	restored := NewCache(repo, "", Options{})
+	found, err := restored.LoadSnapshot(context.Background(), path)
+	if err != nil || !found {
+		t.Fatalf("LoadSnapshot = %v, %v", found, err)
+	}
+	if got := repo.Gets() - gets; got != 2 {
+		t.Fatalf("restore read %d orders from the repo, want only the 2 changed", got)
+	}
+	for uid, track := range map[string]string{"a": "", "b": "changed", "c": ""} {
+		o, found, _ := restored.GetOrder(context.Background(), uid)
+		if !found || o.TrackNumber != track {
+			t.Fatalf("%s: found=%v order=%+v", uid, found, o)
+		}
+	}
+	if got := repo.Gets() - gets; got != 2 {
+		t.Fatal("restored orders were read from the repo again")
+	}
+}
+
+func TestCache_SnapshotKeepsRecency(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.gob")
+	repo := storagetest.NewRepo("a", "b", "c")
+	ctx := context.Background()
+
+	c := NewCache(repo, "", Options{})
+	for _, uid := range []string{"a", "b", "c", "a"} {
+		if _, found, _ := c.GetOrder(ctx, uid); !found {
+			t.Fatalf("%s not found", uid)
+		}
+	}
+	if _, err := c.SaveSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// после загрузки первым вытесняется самый холодный заказ, b
+	restored := NewCache(repo, "", Options{MaxEntries: 3})
+	if _, err := restored.LoadSnapshot(ctx, path); err != nil {
+		t.Fatal(err)
+	}
+	repo.Put(structs.Order{OrderUID: "d"})
+	if _, found, _ := restored.GetOrder(ctx, "d"); !found {
+		t.Fatal("d not found")
+	}
+	uids, _ := restored.UIDs(ctx, "", 0)
+	if !slices.Equal(uids, []string{"a", "c", "d"}) {
+		t.Fatalf("cached %v, want the coldest order b evicted", uids)
+	}
+}
+
+func TestCache_LoadSnapshotMissing(t *testing.T) {
+	c := NewCache(storagetest.NewRepo(), "", Options{})
+	found, err := c.LoadSnapshot(context.Background(), filepath.Join(t.TempDir(), "none.gob"))
+	if found || err != nil {
+		t.Fatalf("LoadSnapshot = %v, %v", found, err)
+	}
+}
+
+func TestCache_InvalidateAndReload(t *testing.T) {
+	repo := storagetest.NewRepo()
+	var changes []string
+	c := NewCache(repo, "", Options{
+		NegativeTTL: time.Minute,
+		OnChange:    func(uid string) { changes = append(changes, uid) },
+	})
+	ctx := context.Background()
+
+	c.SetOrder(context.Background(), &structs.Order{OrderUID: "a", TrackNumber: "old"})
+	repo.Put(structs.Order{OrderUID: "a", TrackNumber: "new"})
+	if err := c.Reload(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if o, _, _ := c.GetOrder(ctx, "a"); o.TrackNumber != "new" {
+		t.Fatalf("reload kept %q", o.TrackNumber)
+	}
+
+	if !c.Invalidate(ctx, "a") || c.Invalidate(ctx, "a") {
+		t.Fatal("Invalidate must report whether the order was cached")
+	}
+
+	// отметка об отсутствии тоже снимается
+	if _, found, _ := c.GetOrder(ctx, "b"); found {
+		t.Fatal("b found")
+	}
+	repo.Put(structs.Order{OrderUID: "b"})
+	if err := c.Reload(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := c.GetOrder(ctx, "b"); !found {
+		t.Fatal("b is still reported missing after reload")
+	}
+
+	if len(changes) != 1 || changes[0] != "a" {
+		t.Fatalf("OnChange calls: %v", changes)
+	}
+}
+
+func orderUIDs(orders []structs.Order) []string {
+	uids := make([]string, len(orders))
+	for i := range orders {
+		uids[i] = orders[i].OrderUID
+	}
+	return uids
+}
+
+func TestCache_Lookup(t *testing.T) {
+	repo := storagetest.NewRepo()
+	day := time.Date(2021, 11, 26, 0, 0, 0, 0, time.UTC)
+	repo.Put(structs.Order{OrderUID: "a", TrackNumber: "T1", DateCreated: day,
+		Items: []structs.Items{{Rid: "r1"}, {Rid: "r2"}}})
+	repo.Put(structs.Order{OrderUID: "b", TrackNumber: "T1", DateCreated: day.Add(time.Hour)})
+	c := NewCache(repo, "", Options{Shards: 4})
+	ctx := context.Background()
+
+	for range 2 {
+		got, err := c.Lookup(ctx, storage.ByTrack, "T1")
+		if err != nil || !slices.Equal(orderUIDs(got), []string{"b", "a"}) {
+			t.Fatalf("by track: %v %v", orderUIDs(got), err)
+		}
+	}
+	if repo.Finds() != 1 {
+		t.Fatalf("second lookup must be served from memory, finds=%d", repo.Finds())
+	}
+	if got, _ := c.Lookup(ctx, storage.ByRid, "r2"); !slices.Equal(orderUIDs(got), []string{"a"}) {
+		t.Fatalf("by rid: %v", orderUIDs(got))
+	}
+
+	// запись через кэш переносит uid между значениями без чтения индекса из БД
+	c.SetOrder(ctx, &structs.Order{OrderUID: "c", TrackNumber: "T1"})
+	c.SetOrder(ctx, &structs.Order{OrderUID: "b", TrackNumber: "T2"})
+	if got, _ := c.Lookup(ctx, storage.ByTrack, "T1"); !slices.Equal(orderUIDs(got), []string{"a", "c"}) {
+		t.Fatalf("after writes: %v", orderUIDs(got))
+	}
+	if repo.Finds() != 2 {
+		t.Fatalf("writes must update loaded entries in place, finds=%d", repo.Finds())
+	}
+
+	// заказ изменили в обход кэша: устаревший uid отсеивается
+	repo.Put(structs.Order{OrderUID: "a", TrackNumber: "T3"})
+	c.Invalidate(ctx, "a")
+	if got, _ := c.Lookup(ctx, storage.ByTrack, "T1"); !slices.Equal(orderUIDs(got), []string{"c"}) {
+		t.Fatalf("stale uid not filtered: %v", orderUIDs(got))
+	}
+
+	if got, err := c.Lookup(ctx, storage.ByCustomer, "nobody"); err != nil || len(got) != 0 {
+		t.Fatalf("unknown customer: %v %v", got, err)
+	}
+	if st := c.Stats(); st.IndexEntries != 3 || st.IndexLoads != 3 || st.IndexHits != 3 {
+		t.Fatalf("unexpected index stats: %+v", st)
+	}
+}
@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"container/list"
+	"time"
+
+	"github.com/CodenSell/WB_test_level0/internal/storage"
+)
+
+// index — вторичный индекс: для значения ключа (трек-номер, транзакция, rid,
+// покупатель) — uid всех заказов с этим значением в БД. Запись появляется после
+// первого поиска по значению; пустой набор — в БД таких заказов нет. Ограничен
+// по числу записей: при переполнении вытесняется давно не запрошенная.
+// Не потокобезопасен.
+type index struct {
+	max   int
+	ll    *list.List
+	items map[indexKey]*list.Element
+}
+
+type indexKey struct {
+	key   storage.LookupKey
+	value string
+}
+
+func (k indexKey) String() string { return string(k.key) + ":" + k.value }
+
+type indexEntry struct {
+	k    indexKey
+	uids map[string]struct{}
+	// expires — после этого момента запись перечитывается из БД; нулевое — никогда.
+	expires time.Time
+}
+
+func newIndex(max int) *index {
+	return &index{max: max, ll: list.New(), items: make(map[indexKey]*list.Element)}
+}
+
+// get возвращает uid для значения ключа; истёкшая запись удаляется.
+func (x *index) get(k indexKey, now time.Time) ([]string, bool) {
+	el, ok := x.items[k]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*indexEntry)
+	if !e.expires.IsZero() && !now.Before(e.expires) {
+		x.removeElement(el)
+		return nil, false
+	}
+	x.ll.MoveToFront(el)
+	uids := make([]string, 0, len(e.uids))
+	for uid := range e.uids {
+		uids = append(uids, uid)
+	}
+	return uids, true
+}
+
+func (x *index) set(k indexKey, uids []string, expires time.Time) {
+	e := &indexEntry{k: k, uids: make(map[string]struct{}, len(uids)), expires: expires}
+	for _, uid := range uids {
+		e.uids[uid] = struct{}{}
+	}
+	if el, ok := x.items[k]; ok {
+		el.Value = e
+		x.ll.MoveToFront(el)
+		return
+	}
+	x.items[k] = x.ll.PushFront(e)
+	for x.max > 0 && x.ll.Len() > x.max {
+		x.removeElement(x.ll.Back())
+	}
+}
+
+// addUID дописывает uid в уже загруженную запись; незагруженные не создаются,
+// иначе в них был бы только этот заказ.
+func (x *index) addUID(k indexKey, uid string) {
+	if el, ok := x.items[k]; ok {
+		el.Value.(*indexEntry).uids[uid] = struct{}{}
+	}
+}
+
+func (x *index) removeUID(k indexKey, uid string) {
+	if el, ok := x.items[k]; ok {
+		delete(el.Value.(*indexEntry).uids, uid)
+	}
+}
+
+func (x *index) removeElement(el *list.Element) {
+	delete(x.items, x.ll.Remove(el).(*indexEntry).k)
+}
+
+func (x *index) len() int { return x.ll.Len() }
@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/CodenSell/WB_test_level0/internal/storage"
+	"github.com/CodenSell/WB_test_level0/internal/structs"
+)
+
+// Lookup ищет заказы по вторичному ключу. uid для значения ключа берутся из
+// индекса в памяти, при промахе — одним запросом к БД; сами заказы — из кэша,
+// а недостающие одним запросом GetOrders. Заказ, у которого этого значения уже
+// нет (изменён в обход кэша), из ответа и из индекса убирается.
+func (a *Cache) Lookup(ctx context.Context, key storage.LookupKey, value string) ([]structs.Order, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, errors.New("empty lookup value")
+	}
+	k := indexKey{key: key, value: value}
+	s := a.shard(k.String())
+
+	s.mu.Lock()
+	uids, ok := s.keys.get(k, a.now())
+	s.mu.Unlock()
+	if ok {
+		a.indexHits.Add(1)
+	} else {
+		ch := a.lookups.DoChan(k.String(), func() (any, error) {
+			a.indexLoads.Add(1)
+			ctx, cancel := detach(ctx, a.opts.LoadTimeout)
+			defer cancel()
+			uids, err := a.repo.FindOrderUIDs(ctx, key, value)
+			if err != nil {
+				return nil, err
+			}
+			s.mu.Lock()
+			s.keys.set(k, uids, a.expiry())
+			s.mu.Unlock()
+			return uids, nil
+		})
+		select {
+		case res := <-ch:
+			if res.Err != nil {
+				return nil, res.Err
+			}
+			uids = res.Val.([]string)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	orders := make([]structs.Order, 0, len(uids))
+	var missed []string
+	for _, uid := range uids {
+		if o, ok := a.lookup(uid); ok {
+			a.hits.Add(1)
+			orders = append(orders, *o)
+		} else {
+			a.misses.Add(1)
+			missed = append(missed, uid)
+		}
+	}
+	if len(missed) > 0 {
+		a.loaded.Add(1)
+		loaded, err := a.repo.GetOrders(ctx, missed)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range loaded {
+			a.put(o)
+		}
+		orders = append(orders, loaded...)
+	}
+
+	found := orders[:0]
+	for _, o := range orders {
+		if key.Has(&o, value) {
+			found = append(found, o)
+		}
+	}
+	if len(found) < len(uids) {
+		// часть заказов удалена или сменила значение ключа
+		keep := make(map[string]bool, len(found))
+		for _, o := range found {
+			keep[o.OrderUID] = true
+		}
+		s.mu.Lock()
+		for _, uid := range uids {
+			if !keep[uid] {
+				s.keys.removeUID(k, uid)
+			}
+		}
+		s.mu.Unlock()
+	}
+
+	return newestFirst(found), nil
+}
+
+// reindex переносит uid заказа между записями вторичного индекса после того,
+// как в кэш легла новая версия o вместо old (nil — старой версии в кэше не было).
+func (a *Cache) reindex(old, o *structs.Order) {
+	for _, key := range storage.LookupKeys {
+		vals := key.Values(o)
+		for _, v := range vals {
+			k := indexKey{key: key, value: v}
+			s := a.shard(k.String())
+			s.mu.Lock()
+			s.keys.addUID(k, o.OrderUID)
+			s.mu.Unlock()
+		}
+		if old == nil {
+			continue
+		}
+		for _, v := range key.Values(old) {
+			if slices.Contains(vals, v) {
+				continue
+			}
+			k := indexKey{key: key, value: v}
+			s := a.shard(k.String())
+			s.mu.Lock()
+			s.keys.removeUID(k, o.OrderUID)
+			s.mu.Unlock()
+		}
+	}
+}
+
+// newestFirst сортирует найденные заказы как FindOrderUIDs — сначала новые —
+// и оставляет не больше storage.MaxLookupOrders.
+func newestFirst(orders []structs.Order) []structs.Order {
+	slices.SortFunc(orders, func(x, y structs.Order) int {
+		if c := y.DateCreated.Compare(x.DateCreated); c != 0 {
+			return c
+		}
+		return cmp.Compare(y.OrderUID, x.OrderUID)
+	})
+	if len(orders) > storage.MaxLookupOrders {
+		orders = orders[:storage.MaxLookupOrders]
+	}
+	return orders
+}
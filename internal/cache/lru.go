@@ -0,0 +1,134 @@
+package cache
+
+import (
+	"container/list"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/CodenSell/WB_test_level0/internal/structs"
+)
+
+// lru — список заказов от недавно использованных к давно использованным
+// с ограничением по числу записей и по примерному объёму. Не потокобезопасен.
+type lru struct {
+	maxEntries int
+	maxBytes   int64
+
+	ll    *list.List
+	items map[string]*list.Element
+	bytes int64
+	// total — общие для всех шардов счётчики; читаются без блокировок шардов.
+	total *usage
+}
+
+// usage — число записей и объём кэша целиком.
+type usage struct {
+	entries, bytes atomic.Int64
+}
+
+type entry struct {
+	order structs.Order
+	size  int64
+	// expires — после этого момента запись считается устаревшей; нулевое — никогда.
+	expires time.Time
+	// refreshing — заказ уже перечитывается из БД в фоне.
+	refreshing bool
+}
+
+func newLRU(maxEntries int, maxBytes int64, total *usage) *lru {
+	return &lru{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+		total:      total,
+	}
+}
+
+func (l *lru) get(uid string) (*entry, bool) {
+	el, ok := l.items[uid]
+	if !ok {
+		return nil, false
+	}
+	l.ll.MoveToFront(el)
+	return el.Value.(*entry), true
+}
+
+// add кладёт или заменяет заказ и возвращает, сколько записей пришлось вытеснить.
+func (l *lru) add(o structs.Order, expires time.Time) (evicted int) {
+	e := &entry{order: o, size: orderSize(&o), expires: expires}
+	if el, ok := l.items[o.OrderUID]; ok {
+		delta := e.size - el.Value.(*entry).size
+		l.bytes += delta
+		l.total.bytes.Add(delta)
+		el.Value = e
+		l.ll.MoveToFront(el)
+	} else {
+		l.items[o.OrderUID] = l.ll.PushFront(e)
+		l.bytes += e.size
+		l.total.entries.Add(1)
+		l.total.bytes.Add(e.size)
+	}
+	for l.over() && l.ll.Len() > 1 {
+		l.removeElement(l.ll.Back())
+		evicted++
+	}
+	return evicted
+}
+
+func (l *lru) remove(uid string) {
+	if el, ok := l.items[uid]; ok {
+		l.removeElement(el)
+	}
+}
+
+func (l *lru) removeElement(el *list.Element) {
+	e := l.ll.Remove(el).(*entry)
+	delete(l.items, e.order.OrderUID)
+	l.bytes -= e.size
+	l.total.entries.Add(-1)
+	l.total.bytes.Add(-e.size)
+}
+
+// clear удаляет все записи.
+func (l *lru) clear() {
+	l.total.entries.Add(-int64(l.ll.Len()))
+	l.total.bytes.Add(-l.bytes)
+	l.ll.Init()
+	l.items = make(map[string]*list.Element)
+	l.bytes = 0
+}
+
+func (l *lru) over() bool {
+	return (l.maxEntries > 0 && l.ll.Len() > l.maxEntries) ||
+		(l.maxBytes > 0 && l.bytes > l.maxBytes)
+}
+
+// room — поместится ли ещё один заказ размера size без вытеснения.
+func (l *lru) room(size int64) bool {
+	return (l.maxEntries <= 0 || l.ll.Len() < l.maxEntries) &&
+		(l.maxBytes <= 0 || l.ll.Len() == 0 || l.bytes+size <= l.maxBytes)
+}
+
+func (l *lru) len() int { return l.ll.Len() }
+
+// orderSize — примерный объём заказа в памяти: структуры плюс содержимое строк.
+// Служебные расходы map и списка учитываются грубо, одной константой.
+func orderSize(o *structs.Order) int64 {
+	const overhead = 128
+	n := int64(unsafe.Sizeof(*o)) + overhead
+	n += int64(len(o.OrderUID) + len(o.TrackNumber) + len(o.Entry) + len(o.Localization) +
+		len(o.InternalSignature) + len(o.CustomerID) + len(o.DeliveryService) + len(o.ShardKey) +
+		len(o.OofShard))
+	d := &o.Delivery
+	n += int64(len(d.Name) + len(d.Phone) + len(d.ZIP) + len(d.City) + len(d.Address) + len(d.Region) + len(d.Email))
+	p := &o.Payment
+	n += int64(len(p.Transaction) + len(p.RequestID) + len(p.Currency) + len(p.Provider) + len(p.Bank))
+	for i := range o.Items {
+		it := &o.Items[i]
+		n += int64(unsafe.Sizeof(*it))
+		n += int64(len(it.TrackNumber) + len(it.Rid) + len(it.Name) + len(it.Size) + len(it.Brand))
+	}
+	return n
+}
@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"container/list"
+	"time"
+)
+
+// negative помнит uid, которых нет в БД, чтобы повторные запросы несуществующих
+// заказов не доходили до Postgres. Ограничен по числу записей: при переполнении
+// вытесняется самая старая. Не потокобезопасен.
+type negative struct {
+	max   int
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type negativeEntry struct {
+	uid     string
+	expires time.Time
+}
+
+func newNegative(max int) *negative {
+	return &negative{max: max, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (n *negative) add(uid string, expires time.Time) {
+	if el, ok := n.items[uid]; ok {
+		el.Value.(*negativeEntry).expires = expires
+		n.ll.MoveToFront(el)
+		return
+	}
+	n.items[uid] = n.ll.PushFront(&negativeEntry{uid: uid, expires: expires})
+	for n.max > 0 && n.ll.Len() > n.max {
+		n.removeElement(n.ll.Back())
+	}
+}
+
+// has сообщает, что uid недавно не нашёлся; истёкшая запись удаляется.
+func (n *negative) has(uid string, now time.Time) bool {
+	el, ok := n.items[uid]
+	if !ok {
+		return false
+	}
+	if !now.Before(el.Value.(*negativeEntry).expires) {
+		n.removeElement(el)
+		return false
+	}
+	return true
+}
+
+func (n *negative) remove(uid string) {
+	if el, ok := n.items[uid]; ok {
+		n.removeElement(el)
+	}
+}
+
+func (n *negative) removeElement(el *list.Element) {
+	delete(n.items, n.ll.Remove(el).(*negativeEntry).uid)
+}
+
+func (n *negative) len() int { return n.ll.Len() }
@@ -0,0 +1,576 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/CodenSell/WB_test_level0/internal/storage"
+	"github.com/CodenSell/WB_test_level0/internal/structs"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	defaultRedisPrefix      = "order:"
+	defaultRedisIndexPrefix = "order-idx:"
+	defaultRedisGenPrefix   = "order-gen:"
+)
+
+const (
+	// redisWriteTimeout ограничивает каждую запись в Redis, отвязанную от
+	// отмены запроса.
+	redisWriteTimeout = 2 * time.Second
+	// redisDropAttempts — сколько раз пробовать убрать заказ после записи в БД.
+	redisDropAttempts = 3
+	// redisGenTTL — сколько живёт поколение ключа; должно быть дольше любого
+	// чтения из БД, иначе сброс поколения пропустит устаревшее значение.
+	redisGenTTL = time.Hour
+)
+
+// indexLoaded — служебный элемент множества индекса: значение уже загружено из
+// БД, даже если заказов с ним нет (пустое множество в Redis не хранится).
+const indexLoaded = ""
+
+// setIfGen записывает значение KEYS[2] со сроком ARGV[3] мс (0 — без срока),
+// только если поколение KEYS[1] всё ещё равно ARGV[1], то есть с момента
+// чтения из БД ключ никто не сбрасывал.
+var setIfGen = redis.NewScript(`
+if (redis.call('GET', KEYS[1]) or '') ~= ARGV[1] then
+  return 0
+end
+if tonumber(ARGV[3]) > 0 then
+  redis.call('SET', KEYS[2], ARGV[2], 'PX', ARGV[3])
+else
+  redis.call('SET', KEYS[2], ARGV[2])
+end
+return 1`)
+
+// replaceSetIfGen — то же для множества индекса: KEYS[2] заменяется элементами
+// ARGV[3:] со сроком ARGV[2] мс.
+var replaceSetIfGen = redis.NewScript(`
+if (redis.call('GET', KEYS[1]) or '') ~= ARGV[1] then
+  return 0
+end
+redis.call('DEL', KEYS[2])
+redis.call('SADD', KEYS[2], unpack(ARGV, 3))
+if tonumber(ARGV[2]) > 0 then
+  redis.call('PEXPIRE', KEYS[2], ARGV[2])
+end
+return 1`)
+
+type RedisOptions struct {
+	Addr     string
+	Password string
+	DB       int
+	// Prefix — префикс ключей, по умолчанию "order:".
+	Prefix string
+	// IndexPrefix — префикс множеств вторичного индекса, по умолчанию "order-idx:".
+	IndexPrefix string
+	// GenPrefix — префикс счётчиков поколений ключей, по умолчанию "order-gen:".
+	GenPrefix string
+	// TTL — срок жизни заказа в Redis; 0 — без срока.
+	TTL time.Duration
+	// NegativeTTL — сколько помнить, что заказа нет в БД; 0 — не помнить.
+	NegativeTTL time.Duration
+	// LoadTimeout ограничивает чтение из БД при промахе; 0 — defaultLoadTimeout.
+	LoadTimeout time.Duration
+	// WarmMaxEntries — сколько заказов самое большее кладёт Warm (как
+	// MaxEntries у встроенного кэша); 0 — без ограничения.
+	WarmMaxEntries int
+	// WarmPageSize — по сколько заказов Warm читает из БД; 0 — defaultWarmPageSize.
+	WarmPageSize int
+	OnChange     func(uid string)
+}
+
+// RedisCache хранит заказы в Redis (или любом сервере с протоколом RESP),
+// поэтому кэш общий для всех экземпляров сервиса. Заказ лежит JSON-ом под
+// ключом Prefix+uid; пустое значение — отметка, что заказа нет в БД.
+// Ошибки Redis на чтении не роняют запрос: заказ читается из БД.
+// Вторичный индекс — множества uid под ключами IndexPrefix+ключ+":"+значение.
+//
+// Запись заказа не кладёт его в Redis, а удаляет ключ и ключи индекса с его
+// значениями и увеличивает их поколения (GenPrefix+ключ). Прочитанное из БД
+// значение записывается, только если поколение не изменилось с начала чтения,
+// поэтому медленный экземпляр не затрёт более новую запись старой версией.
+type RedisCache struct {
+	rdb     *redis.Client
+	repo    storage.OrderRepo
+	opts    RedisOptions
+	loads   singleflight.Group
+	lookups singleflight.Group
+
+	hits, misses, loaded, negativeHits atomic.Int64
+
+	indexHits, indexLoads atomic.Int64
+}
+
+func NewRedisCache(ctx context.Context, repo storage.OrderRepo, opts RedisOptions) (*RedisCache, error) {
+	if opts.Prefix == "" {
+		opts.Prefix = defaultRedisPrefix
+	}
+	if opts.IndexPrefix == "" {
+		opts.IndexPrefix = defaultRedisIndexPrefix
+	}
+	if opts.GenPrefix == "" {
+		opts.GenPrefix = defaultRedisGenPrefix
+	}
+	if opts.LoadTimeout <= 0 {
+		opts.LoadTimeout = defaultLoadTimeout
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: opts.Addr, Password: opts.Password, DB: opts.DB})
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		_ = rdb.Close()
+		return nil, err
+	}
+	return &RedisCache{rdb: rdb, repo: repo, opts: opts}, nil
+}
+
+func (c *RedisCache) key(uid string) string { return c.opts.Prefix + uid }
+
+func (c *RedisCache) indexKey(k indexKey) string { return c.opts.IndexPrefix + k.String() }
+
+func (c *RedisCache) genKey(key string) string { return c.opts.GenPrefix + key }
+
+func (c *RedisCache) GetOrder(ctx context.Context, uid string) (*structs.Order, bool, error) {
+	uid = strings.TrimSpace(uid)
+	if uid == "" {
+		return nil, false, errors.New("empty uid")
+	}
+
+	data, err := c.rdb.Get(ctx, c.key(uid)).Bytes()
+	switch {
+	case err == nil && len(data) == 0:
+		c.misses.Add(1)
+		c.negativeHits.Add(1)
+		return nil, false, nil
+	case err == nil:
+		var o structs.Order
+		if err := json.Unmarshal(data, &o); err == nil {
+			c.hits.Add(1)
+			return &o, true, nil
+		}
+		log.Printf("redis cache: bad value for %s: %v", uid, err)
+	case !errors.Is(err, redis.Nil):
+		log.Printf("redis cache get %s: %v", uid, err)
+	}
+	c.misses.Add(1)
+
+	ch := c.loads.DoChan(uid, func() (any, error) {
+		ctx, cancel := detach(ctx, c.opts.LoadTimeout)
+		defer cancel()
+		return c.load(ctx, uid)
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			if errors.Is(res.Err, sql.ErrNoRows) {
+				return nil, false, nil
+			}
+			return nil, false, res.Err
+		}
+		o := *res.Val.(*structs.Order)
+		return &o, true, nil
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+}
+
+func (c *RedisCache) load(ctx context.Context, uid string) (*structs.Order, error) {
+	c.loaded.Add(1)
+	gen, genErr := c.gen(ctx, c.key(uid))
+	o, err := c.repo.GetOrder(ctx, uid)
+	if genErr != nil {
+		log.Printf("redis cache gen %s: %v", uid, genErr)
+	} else if errors.Is(err, sql.ErrNoRows) && c.opts.NegativeTTL > 0 {
+		c.fill(ctx, uid, nil, gen)
+	} else if err == nil {
+		c.fill(ctx, uid, o, gen)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func (c *RedisCache) CreateOrder(ctx context.Context, o *structs.Order, meta storage.Meta) (bool, error) {
+	if o == nil {
+		return false, errors.New("nil order")
+	}
+	uid := strings.TrimSpace(o.OrderUID)
+	if uid == "" {
+		return false, errors.New("empty order_uid")
+	}
+	created, err := c.repo.UpsertOrder(ctx, o, meta)
+	if err != nil {
+		return false, err
+	}
+	c.drop(ctx, uid, o)
+	c.changed(uid)
+	return created, nil
+}
+
+// SetOrder убирает из Redis прежнюю версию заказа, уже сохранённого в БД;
+// новая загрузится при следующем чтении.
+func (c *RedisCache) SetOrder(ctx context.Context, o *structs.Order) {
+	c.drop(ctx, o.OrderUID, o)
+	c.changed(o.OrderUID)
+}
+
+func (c *RedisCache) Invalidate(ctx context.Context, uid string) bool {
+	return c.drop(ctx, uid, nil)
+}
+
+func (c *RedisCache) Reload(ctx context.Context, uid string) error {
+	n, err := c.rdb.Exists(ctx, c.key(uid)).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return nil
+	}
+	c.drop(ctx, uid, nil)
+	if _, err := c.load(ctx, uid); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	return nil
+}
+
+// Lookup ищет заказы по вторичному ключу: uid берутся из множества индекса
+// (при его отсутствии — из БД), заказы — одним MGET, недостающие — из БД.
+// Заказы, у которых значения уже нет, убираются из ответа и из множества.
+func (c *RedisCache) Lookup(ctx context.Context, key storage.LookupKey, value string) ([]structs.Order, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, errors.New("empty lookup value")
+	}
+	ikey := c.indexKey(indexKey{key: key, value: value})
+
+	members, err := c.rdb.SMembers(ctx, ikey).Result()
+	if err != nil {
+		log.Printf("redis cache smembers %s: %v", ikey, err)
+	}
+	var uids []string
+	if len(members) > 0 {
+		c.indexHits.Add(1)
+		for _, m := range members {
+			if m != indexLoaded {
+				uids = append(uids, m)
+			}
+		}
+	} else {
+		ch := c.lookups.DoChan(ikey, func() (any, error) {
+			ctx, cancel := detach(ctx, c.opts.LoadTimeout)
+			defer cancel()
+			return c.loadIndex(ctx, ikey, key, value)
+		})
+		select {
+		case res := <-ch:
+			if res.Err != nil {
+				return nil, res.Err
+			}
+			uids = res.Val.([]string)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if len(uids) == 0 {
+		return []structs.Order{}, nil
+	}
+
+	keys := make([]string, len(uids))
+	for i, uid := range uids {
+		keys[i] = c.key(uid)
+	}
+	vals, err := c.rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		log.Printf("redis cache mget: %v", err)
+		vals = make([]any, len(uids))
+	}
+	orders := make([]structs.Order, 0, len(uids))
+	var missed []string
+	for i, v := range vals {
+		var o structs.Order
+		if data, ok := v.(string); ok && data != "" && json.Unmarshal([]byte(data), &o) == nil {
+			c.hits.Add(1)
+			orders = append(orders, o)
+			continue
+		}
+		c.misses.Add(1)
+		missed = append(missed, uids[i])
+	}
+	if len(missed) > 0 {
+		c.loaded.Add(1)
+		loaded, err := c.loadOrders(ctx, missed)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, loaded...)
+	}
+
+	found := orders[:0]
+	keep := make(map[string]bool, len(orders))
+	for _, o := range orders {
+		if key.Has(&o, value) {
+			found = append(found, o)
+			keep[o.OrderUID] = true
+		}
+	}
+	var stale []any
+	for _, uid := range uids {
+		if !keep[uid] {
+			stale = append(stale, uid)
+		}
+	}
+	if len(stale) > 0 {
+		if err := c.rdb.SRem(ctx, ikey, stale...).Err(); err != nil {
+			log.Printf("redis cache srem %s: %v", ikey, err)
+		}
+	}
+
+	return newestFirst(found), nil
+}
+
+// loadIndex читает uid для значения ключа из БД и заменяет ими множество индекса.
+// Множество не заменяется, если его сбросила запись заказа, пока шло чтение.
+func (c *RedisCache) loadIndex(ctx context.Context, ikey string, key storage.LookupKey, value string) ([]string, error) {
+	c.indexLoads.Add(1)
+	gen, genErr := c.gen(ctx, ikey)
+	uids, err := c.repo.FindOrderUIDs(ctx, key, value)
+	if err != nil {
+		return nil, err
+	}
+	if genErr != nil {
+		log.Printf("redis cache gen %s: %v", ikey, genErr)
+		return uids, nil
+	}
+	args := make([]any, 0, len(uids)+3)
+	args = append(args, gen, c.opts.TTL.Milliseconds(), indexLoaded)
+	for _, uid := range uids {
+		args = append(args, uid)
+	}
+	wctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), redisWriteTimeout)
+	defer cancel()
+	if err := replaceSetIfGen.Run(wctx, c.rdb, []string{c.genKey(ikey), ikey}, args...).Err(); err != nil {
+		log.Printf("redis cache index %s: %v", ikey, err)
+	}
+	return uids, nil
+}
+
+// Stats — счётчики этого экземпляра; число и объём записей общие для всех
+// экземпляров и здесь не считаются.
+func (c *RedisCache) Stats() Stats {
+	return Stats{
+		Hits:         c.hits.Load(),
+		Misses:       c.misses.Load(),
+		Loads:        c.loaded.Load(),
+		NegativeHits: c.negativeHits.Load(),
+		IndexHits:    c.indexHits.Load(),
+		IndexLoads:   c.indexLoads.Load(),
+	}
+}
+
+// UIDs обходит ключи через SCAN; для админки этого достаточно, но на
+// больших базах это не дешёвая операция.
+func (c *RedisCache) UIDs(ctx context.Context, after string, limit int) ([]string, error) {
+	var uids []string
+	iter := c.rdb.Scan(ctx, 0, c.opts.Prefix+"*", 1000).Iterator()
+	for iter.Next(ctx) {
+		if uid := strings.TrimPrefix(iter.Val(), c.opts.Prefix); uid > after {
+			uids = append(uids, uid)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	slices.Sort(uids)
+	if limit > 0 && len(uids) > limit {
+		uids = uids[:limit]
+	}
+	return uids, nil
+}
+
+// Flush удаляет все ключи кэша и индекса по их префиксам, остальные данные
+// Redis не трогает.
+func (c *RedisCache) Flush(ctx context.Context) error {
+	for _, prefix := range []string{c.opts.Prefix, c.opts.IndexPrefix, c.opts.GenPrefix} {
+		if err := c.deleteByPrefix(ctx, prefix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *RedisCache) deleteByPrefix(ctx context.Context, prefix string) error {
+	iter := c.rdb.Scan(ctx, 0, prefix+"*", 1000).Iterator()
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) == 1000 {
+			if err := c.rdb.Del(ctx, keys...).Err(); err != nil {
+				return err
+			}
+			keys = keys[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		return c.rdb.Del(ctx, keys...).Err()
+	}
+	return nil
+}
+
+// Warm записывает в Redis заказы из БД страницами по WarmPageSize, по
+// конвейеру на страницу, но не больше WarmMaxEntries.
+func (c *RedisCache) Warm(ctx context.Context) (int, error) {
+	uids, err := c.repo.ListOrderUIDs(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if limit := c.opts.WarmMaxEntries; limit > 0 && len(uids) > limit {
+		log.Printf("redis cache warm-up limited to %d of %d orders", limit, len(uids))
+		uids = uids[:limit]
+	}
+	pageSize := c.opts.WarmPageSize
+	if pageSize < 1 {
+		pageSize = defaultWarmPageSize
+	}
+	loaded := 0
+	for start := 0; start < len(uids); start += pageSize {
+		orders, err := c.loadOrders(ctx, uids[start:min(start+pageSize, len(uids))])
+		if err != nil {
+			return loaded, err
+		}
+		loaded += len(orders)
+	}
+	log.Printf("redis cache warm-up done: %d orders", loaded)
+	return loaded, nil
+}
+
+func (c *RedisCache) Close() error {
+	return c.rdb.Close()
+}
+
+// gen возвращает текущее поколение ключа; "" — ключ ещё не сбрасывался.
+func (c *RedisCache) gen(ctx context.Context, key string) (string, error) {
+	gen, err := c.rdb.Get(ctx, c.genKey(key)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return gen, err
+}
+
+// loadOrders читает заказы из БД и записывает их в Redis с поколениями,
+// прочитанными до обращения к БД.
+func (c *RedisCache) loadOrders(ctx context.Context, uids []string) ([]structs.Order, error) {
+	genKeys := make([]string, len(uids))
+	for i, uid := range uids {
+		genKeys[i] = c.genKey(c.key(uid))
+	}
+	gens, genErr := c.rdb.MGet(ctx, genKeys...).Result()
+	orders, err := c.repo.GetOrders(ctx, uids)
+	if err != nil {
+		return nil, err
+	}
+	if genErr != nil {
+		log.Printf("redis cache gens: %v", genErr)
+		return orders, nil
+	}
+	byUID := make(map[string]string, len(uids))
+	for i, uid := range uids {
+		gen, _ := gens[i].(string)
+		byUID[uid] = gen
+	}
+
+	wctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), redisWriteTimeout)
+	defer cancel()
+	_, err = c.rdb.Pipelined(wctx, func(p redis.Pipeliner) error {
+		for i := range orders {
+			uid := orders[i].OrderUID
+			data, err := json.Marshal(&orders[i])
+			if err != nil {
+				return err
+			}
+			setIfGen.Eval(wctx, p, []string{c.genKey(c.key(uid)), c.key(uid)}, byUID[uid], data, c.opts.TTL.Milliseconds())
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("redis cache set orders: %v", err)
+	}
+	return orders, nil
+}
+
+// fill записывает прочитанный из БД заказ, если поколение его ключа всё ещё
+// gen; o == nil — отметка, что заказа нет в БД.
+func (c *RedisCache) fill(ctx context.Context, uid string, o *structs.Order, gen string) {
+	data, ttl := []byte{}, c.opts.NegativeTTL
+	if o != nil {
+		var err error
+		if data, err = json.Marshal(o); err != nil {
+			log.Printf("redis cache marshal %s: %v", uid, err)
+			return
+		}
+		ttl = c.opts.TTL
+	}
+	wctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), redisWriteTimeout)
+	defer cancel()
+	err := setIfGen.Run(wctx, c.rdb, []string{c.genKey(c.key(uid)), c.key(uid)}, gen, data, ttl.Milliseconds()).Err()
+	if err != nil {
+		log.Printf("redis cache set %s: %v", uid, err)
+	}
+}
+
+// drop убирает заказ и множества индекса с его значениями (если o задан) и
+// увеличивает их поколения. Вызывается после записи в БД, поэтому не зависит от
+// отмены ctx и повторяется при ошибке: оставшуюся старую версию иначе видели бы
+// все экземпляры до истечения TTL. Возвращает, был ли заказ в Redis.
+func (c *RedisCache) drop(ctx context.Context, uid string, o *structs.Order) bool {
+	keys := []string{c.key(uid)}
+	if o != nil {
+		for _, key := range storage.LookupKeys {
+			for _, v := range key.Values(o) {
+				keys = append(keys, c.indexKey(indexKey{key: key, value: v}))
+			}
+		}
+	}
+	var err error
+	for attempt := range redisDropAttempts {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+		}
+		var del *redis.IntCmd
+		wctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), redisWriteTimeout)
+		_, err = c.rdb.TxPipelined(wctx, func(p redis.Pipeliner) error {
+			for _, key := range keys {
+				p.Incr(wctx, c.genKey(key))
+				p.Expire(wctx, c.genKey(key), redisGenTTL)
+			}
+			del = p.Del(wctx, keys[0])
+			if len(keys) > 1 {
+				p.Del(wctx, keys[1:]...)
+			}
+			return nil
+		})
+		cancel()
+		if err == nil {
+			return del.Val() > 0
+		}
+	}
+	log.Printf("redis cache drop %s: %v", uid, err)
+	return false
+}
+
+func (c *RedisCache) changed(uid string) {
+	if c.opts.OnChange != nil {
+		c.opts.OnChange(uid)
+	}
+}
@@ -0,0 +1,246 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/CodenSell/WB_test_level0/internal/storage"
+	"github.com/CodenSell/WB_test_level0/internal/storage/storagetest"
+	"github.com/CodenSell/WB_test_level0/internal/structs"
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newTestRedisCache(t *testing.T, repo *storagetest.Repo, opts RedisOptions) (*RedisCache, *miniredis.Miniredis) {
+	t.Helper()
+	srv := miniredis.RunT(t)
+	opts.Addr = srv.Addr()
+	c, err := NewRedisCache(context.Background(), repo, opts)
+	if err != nil {
+		t.Fatalf("NewRedisCache: %v", err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return c, srv
+}
+
+func TestRedisCache_ReadThrough(t *testing.T) {
+	repo := storagetest.NewRepo("a")
+	c, srv := newTestRedisCache(t, repo, RedisOptions{TTL: time.Minute})
+	ctx := context.Background()
+
+	for range 2 {
+		o, found, err := c.GetOrder(ctx, "a")
+		if err != nil || !found || o.OrderUID != "a" {
+			t.Fatalf("got %+v %v %v", o, found, err)
+		}
+	}
+	if got := repo.Gets(); got != 1 {
+		t.Fatalf("repo.GetOrder called %d times, want 1", got)
+	}
+	if !srv.Exists("order:a") || srv.TTL("order:a") != time.Minute {
+		t.Fatalf("order not stored with TTL, ttl=%v", srv.TTL("order:a"))
+	}
+	if st := c.Stats(); st.Hits != 1 || st.Misses != 1 || st.Loads != 1 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestRedisCache_SharedBetweenInstances(t *testing.T) {
+	repo := storagetest.NewRepo()
+	first, srv := newTestRedisCache(t, repo, RedisOptions{})
+	second, err := NewRedisCache(context.Background(), repo, RedisOptions{Addr: srv.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	ctx := context.Background()
+
+	if _, err := first.CreateOrder(ctx, &structs.Order{OrderUID: "a", TrackNumber: "T"}, storage.Meta{}); err != nil {
+		t.Fatal(err)
+	}
+	if srv.Exists("order:a") {
+		t.Fatal("write must drop the key instead of storing the order")
+	}
+	o, found, err := second.GetOrder(ctx, "a")
+	if err != nil || !found || o.TrackNumber != "T" {
+		t.Fatalf("got %+v %v %v", o, found, err)
+	}
+	if o, found, _ := first.GetOrder(ctx, "a"); !found || o.TrackNumber != "T" || repo.Gets() != 1 {
+		t.Fatalf("first instance must read what the second loaded, gets=%d", repo.Gets())
+	}
+
+	if !second.Invalidate(ctx, "a") || srv.Exists("order:a") {
+		t.Fatal("Invalidate did not delete the key")
+	}
+}
+
+func TestRedisCache_Negative(t *testing.T) {
+	repo := storagetest.NewRepo()
+	c, _ := newTestRedisCache(t, repo, RedisOptions{NegativeTTL: time.Minute})
+	ctx := context.Background()
+
+	for range 3 {
+		if _, found, err := c.GetOrder(ctx, "ghost"); found || err != nil {
+			t.Fatalf("ghost: found=%v err=%v", found, err)
+		}
+	}
+	if got := repo.Gets(); got != 1 {
+		t.Fatalf("repo.GetOrder called %d times, want 1", got)
+	}
+
+	repo.Put(structs.Order{OrderUID: "ghost"})
+	c.SetOrder(ctx, &structs.Order{OrderUID: "ghost"})
+	if _, found, _ := c.GetOrder(ctx, "ghost"); !found {
+		t.Fatal("stored order is still reported missing")
+	}
+
+	if st := c.Stats(); st.NegativeHits != 2 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestRedisCache_Reload(t *testing.T) {
+	repo := storagetest.NewRepo()
+	c, _ := newTestRedisCache(t, repo, RedisOptions{})
+	ctx := context.Background()
+
+	c.SetOrder(ctx, &structs.Order{OrderUID: "a", TrackNumber: "old"})
+	repo.Put(structs.Order{OrderUID: "a", TrackNumber: "new"})
+	if err := c.Reload(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if o, _, _ := c.GetOrder(ctx, "a"); o.TrackNumber != "new" {
+		t.Fatalf("reload kept %q", o.TrackNumber)
+	}
+
+	// заказа нет в кэше — Reload в БД не ходит
+	gets := repo.Gets()
+	if err := c.Reload(ctx, "absent"); err != nil || repo.Gets() != gets {
+		t.Fatalf("Reload of an uncached order: err=%v gets=%d", err, repo.Gets()-gets)
+	}
+}
+
+func TestRedisCache_UIDsFlushWarm(t *testing.T) {
+	repo := storagetest.NewRepo("a", "b", "c")
+	c, srv := newTestRedisCache(t, repo, RedisOptions{})
+	ctx := context.Background()
+	srv.Set("unrelated", "keep")
+
+	if n, err := c.Warm(ctx); err != nil || n != 3 {
+		t.Fatalf("Warm = %d, %v", n, err)
+	}
+	uids, err := c.UIDs(ctx, "a", 10)
+	if err != nil || len(uids) != 2 || uids[0] != "b" || uids[1] != "c" {
+		t.Fatalf("UIDs = %v, %v", uids, err)
+	}
+
+	if err := c.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if uids, _ := c.UIDs(ctx, "", 10); len(uids) != 0 {
+		t.Fatalf("UIDs after flush: %v", uids)
+	}
+	if !srv.Exists("unrelated") {
+		t.Fatal("Flush removed a key outside the cache prefix")
+	}
+}
+
+func TestRedisCache_Lookup(t *testing.T) {
+	repo := storagetest.NewRepo()
+	repo.Put(structs.Order{OrderUID: "a", Payment: structs.Payment{Transaction: "tx"}})
+	c, srv := newTestRedisCache(t, repo, RedisOptions{TTL: time.Minute})
+	ctx := context.Background()
+
+	for range 2 {
+		got, err := c.Lookup(ctx, storage.ByTransaction, "tx")
+		if err != nil || len(got) != 1 || got[0].OrderUID != "a" {
+			t.Fatalf("by transaction: %+v %v", got, err)
+		}
+	}
+	if repo.Finds() != 1 || repo.Gets() != 1 {
+		t.Fatalf("second lookup must be served from redis, finds=%d gets=%d", repo.Finds(), repo.Gets())
+	}
+	if srv.TTL("order-idx:transaction:tx") != time.Minute {
+		t.Fatalf("index set not stored with TTL")
+	}
+
+	// запись сбрасывает множество нового значения, и оно перечитывается из БД;
+	// заказ с другой транзакцией убирается из множества при поиске
+	if _, err := c.CreateOrder(ctx, &structs.Order{OrderUID: "b", Payment: structs.Payment{Transaction: "tx"}}, storage.Meta{}); err != nil {
+		t.Fatal(err)
+	}
+	repo.Put(structs.Order{OrderUID: "a", Payment: structs.Payment{Transaction: "tx2"}})
+	c.SetOrder(ctx, &structs.Order{OrderUID: "a", Payment: structs.Payment{Transaction: "tx2"}})
+	got, err := c.Lookup(ctx, storage.ByTransaction, "tx")
+	if err != nil || len(got) != 1 || got[0].OrderUID != "b" {
+		t.Fatalf("after writes: %+v %v", got, err)
+	}
+	if ok, _ := srv.SIsMember("order-idx:transaction:tx", "a"); ok {
+		t.Fatalf("stale uid must be removed from the index set")
+	}
+
+	if got, err := c.Lookup(ctx, storage.ByRid, "none"); err != nil || len(got) != 0 {
+		t.Fatalf("unknown rid: %+v %v", got, err)
+	}
+	if !srv.Exists("order-idx:rid:none") {
+		t.Fatalf("empty result must be remembered")
+	}
+
+	if err := c.Flush(ctx); err != nil || len(srv.Keys()) != 0 {
+		t.Fatalf("flush left keys: %v %v", srv.Keys(), err)
+	}
+}
+
+func TestRedisCache_StaleFillSkipped(t *testing.T) {
+	repo := storagetest.NewRepo()
+	repo.Put(structs.Order{OrderUID: "a", TrackNumber: "old"})
+	c, srv := newTestRedisCache(t, repo, RedisOptions{})
+	ctx := context.Background()
+
+	// чтение из БД началось до записи, а закончилось после
+	gen, err := c.gen(ctx, c.key("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CreateOrder(ctx, &structs.Order{OrderUID: "a", TrackNumber: "new"}, storage.Meta{}); err != nil {
+		t.Fatal(err)
+	}
+	c.fill(ctx, "a", &structs.Order{OrderUID: "a", TrackNumber: "old"}, gen)
+	if srv.Exists("order:a") {
+		t.Fatal("order read before the write overwrote the newer version")
+	}
+
+	if o, _, _ := c.GetOrder(ctx, "a"); o.TrackNumber != "new" {
+		t.Fatalf("got %q", o.TrackNumber)
+	}
+	if !srv.Exists("order:a") {
+		t.Fatal("fresh read must be stored")
+	}
+}
+
+func TestRedisCache_DropIgnoresCancel(t *testing.T) {
+	repo := storagetest.NewRepo("a")
+	c, srv := newTestRedisCache(t, repo, RedisOptions{})
+	if _, _, err := c.GetOrder(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.SetOrder(ctx, &structs.Order{OrderUID: "a"})
+	if srv.Exists("order:a") {
+		t.Fatal("cancelled request left the old version in redis")
+	}
+}
+
+func TestRedisCache_WarmLimit(t *testing.T) {
+	repo := storagetest.NewRepo("a", "b", "c", "d", "e")
+	c, _ := newTestRedisCache(t, repo, RedisOptions{WarmMaxEntries: 3, WarmPageSize: 2})
+
+	if n, err := c.Warm(context.Background()); err != nil || n != 3 {
+		t.Fatalf("Warm = %d, %v; want 3", n, err)
+	}
+	if uids, _ := c.UIDs(context.Background(), "", 10); len(uids) != 3 {
+		t.Fatalf("redis holds %v, want 3 orders", uids)
+	}
+}
@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// shard — часть кэша со своей блокировкой; заказ попадает в шард по хэшу uid.
+type shard struct {
+	mu    sync.Mutex
+	cache *lru
+	// missing — uid, недавно не найденные в БД.
+	missing *negative
+	// keys — записи вторичного индекса, попавшие в шард по хэшу значения ключа.
+	keys *index
+}
+
+func newShards(opts Options, total *usage) []*shard {
+	n := max(opts.Shards, 1)
+	if opts.MaxEntries > 0 {
+		// иначе части шардов достался бы нулевой лимит, то есть никакого
+		n = min(n, opts.MaxEntries)
+	}
+	shards := make([]*shard, n)
+	for i := range shards {
+		shards[i] = &shard{
+			cache:   newLRU(perShard(opts.MaxEntries, n, i), perShard(opts.MaxBytes, n, i), total),
+			missing: newNegative(perShard(opts.NegativeMaxEntries, n, i)),
+			keys:    newIndex(perShard(opts.IndexMaxEntries, n, i)),
+		}
+	}
+	return shards
+}
+
+// perShard — доля общего лимита для i-го из n шардов: сумма долей равна limit,
+// но не меньше 1 на шард; 0 — без лимита.
+func perShard[T int | int64](limit T, n, i int) T {
+	if limit <= 0 {
+		return 0
+	}
+	share := limit / T(n)
+	if T(i) < limit%T(n) {
+		share++
+	}
+	return max(share, 1)
+}
+
+func (a *Cache) shard(uid string) *shard {
+	if len(a.shards) == 1 {
+		return a.shards[0]
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(uid))
+	return a.shards[h.Sum32()%uint32(len(a.shards))]
+}
@@ -0,0 +1,135 @@
+package cache
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/CodenSell/WB_test_level0/internal/structs"
+)
+
+const (
+	snapshotFormat = 3
+	// snapshotSkew — запас при догрузке изменений: часы приложения и БД могут
+	// расходиться, а updated_at ставится в начале транзакции, а не при коммите.
+	snapshotSkew = time.Minute
+)
+
+// snapshot — содержимое файла снимка кэша. Оффсеты Kafka в нём не нужны:
+// всё, что консюмер записал после снимка, находится в БД по updated_at.
+type snapshot struct {
+	Format  int
+	TakenAt time.Time
+	// Orders — в каждом шарде от давно использованных к недавно использованным,
+	// чтобы после загрузки горячие заказы снова оказались в голове LRU.
+	Orders []structs.Order
+}
+
+// SaveSnapshot записывает все заказы кэша в path (gob). Файл заменяется
+// атомарно: сначала пишется временный, потом переименовывается.
+func (a *Cache) SaveSnapshot(path string) (int, error) {
+	snap := snapshot{Format: snapshotFormat, TakenAt: a.now()}
+	for _, s := range a.shards {
+		s.mu.Lock()
+		for el := s.cache.ll.Back(); el != nil; el = el.Prev() {
+			snap.Orders = append(snap.Orders, el.Value.(*entry).order)
+		}
+		s.mu.Unlock()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	if err := gob.NewEncoder(tmp).Encode(&snap); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, err
+	}
+	return len(snap.Orders), nil
+}
+
+// LoadSnapshot кладёт в кэш заказы из снимка и догружает из БД те, что
+// изменились после него. found — снимок был и прочитан; без него кэш нужно
+// прогревать через Warm.
+func (a *Cache) LoadSnapshot(ctx context.Context, path string) (found bool, err error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	var snap snapshot
+	if err := gob.NewDecoder(f).Decode(&snap); err != nil {
+		return false, fmt.Errorf("decode snapshot %s: %w", path, err)
+	}
+	if snap.Format != snapshotFormat {
+		return false, fmt.Errorf("snapshot %s has format %d, want %d", path, snap.Format, snapshotFormat)
+	}
+
+	// если лимит кэша с тех пор уменьшили, вытеснятся самые холодные
+	for i := range snap.Orders {
+		a.put(snap.Orders[i])
+	}
+	log.Printf("cache snapshot from %s: %d orders", snap.TakenAt.Format(time.RFC3339), len(snap.Orders))
+
+	uids, err := a.repo.ListOrderUIDsChangedSince(ctx, snap.TakenAt.Add(-snapshotSkew))
+	if err != nil {
+		return true, err
+	}
+	pageSize := a.opts.WarmPageSize
+	if pageSize < 1 {
+		pageSize = defaultWarmPageSize
+	}
+	changed := 0
+	for start := 0; start < len(uids); start += pageSize {
+		orders, err := a.repo.GetOrders(ctx, uids[start:min(start+pageSize, len(uids))])
+		if err != nil {
+			return true, err
+		}
+		for i := range orders {
+			a.put(orders[i])
+		}
+		changed += len(orders)
+	}
+	log.Printf("cache snapshot: %d orders changed since snapshot reloaded from DB", changed)
+	return true, nil
+}
+
+// RunSnapshots сохраняет снимок каждые every, пока не отменён ctx, и ещё раз
+// при остановке.
+func (a *Cache) RunSnapshots(ctx context.Context, path string, every time.Duration) {
+	save := func() {
+		n, err := a.SaveSnapshot(path)
+		if err != nil {
+			log.Printf("cache snapshot error: %v", err)
+			return
+		}
+		log.Printf("cache snapshot saved: %d orders", n)
+	}
+
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			save()
+		case <-ctx.Done():
+			save()
+			return
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultWarmPageSize = 500
+	warmProgressEvery   = 5 * time.Second
+)
+
+// Warm заполняет кэш заказами из БД. Список uid делится на страницы по
+// WarmPageSize, страницы читаются GetOrders в WarmWorkers потоков и сразу
+// попадают в кэш — в заполненный шард заказ не кладётся, вытеснений прогрев
+// не вызывает. Прогрев останавливается, когда кэш заполнен; возвращает
+// число загруженных заказов.
+func (a *Cache) Warm(ctx context.Context) (int, error) {
+	started := time.Now()
+	uids, err := a.repo.ListOrderUIDs(ctx)
+	if err != nil {
+		return 0, err
+	}
+	workers := max(a.opts.WarmWorkers, 1)
+	pageSize := a.opts.WarmPageSize
+	if pageSize < 1 {
+		pageSize = defaultWarmPageSize
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		loaded   atomic.Int64
+		errOnce  sync.Once
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	pages := make(chan []string)
+	for range workers {
+		wg.Go(func() {
+			for page := range pages {
+				if a.full() {
+					// страницы, отправленные до заполнения, только вычитываются:
+					// иначе отправитель навсегда встанет на pages
+					continue
+				}
+				orders, err := a.repo.GetOrders(ctx, page)
+				if err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					return
+				}
+				for i := range orders {
+					if a.full() {
+						break
+					}
+					if a.fill(orders[i]) {
+						loaded.Add(1)
+					}
+				}
+			}
+		})
+	}
+
+	progress := time.NewTicker(warmProgressEvery)
+	defer progress.Stop()
+	go func() {
+		for {
+			select {
+			case <-progress.C:
+				log.Printf("cache warm-up: %d of %d orders loaded", loaded.Load(), len(uids))
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+send:
+	for start := 0; start < len(uids); start += pageSize {
+		if a.full() {
+			log.Printf("cache is full, warm-up stopped")
+			break
+		}
+		select {
+		case pages <- uids[start:min(start+pageSize, len(uids))]:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(pages)
+	wg.Wait()
+
+	n := int(loaded.Load())
+	if firstErr == nil {
+		firstErr = parent.Err()
+	}
+	log.Printf("cache warm-up done: %d of %d orders in %s", n, len(uids), time.Since(started).Round(time.Millisecond))
+	return n, firstErr
+}
@@ -0,0 +1,147 @@
+package diff
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CodenSell/WB_test_level0/internal/structs"
+)
+
+// Change — изменение одного скалярного поля; Field — имя из json-тега.
+type Change struct {
+	Field string `json:"field"`
+	From  any    `json:"from"`
+	To    any    `json:"to"`
+}
+
+type ItemChange struct {
+	// Key — по чему сопоставлены товары: rid:... или chrt_id:...
+	Key     string   `json:"key"`
+	Changes []Change `json:"changes"`
+}
+
+type OrderDiff struct {
+	Fields       []Change             `json:"fields,omitempty"`
+	Delivery     []Change             `json:"delivery,omitempty"`
+	Payment      []Change             `json:"payment,omitempty"`
+	ItemsAdded   []structs.PricedItem `json:"items_added,omitempty"`
+	ItemsRemoved []structs.PricedItem `json:"items_removed,omitempty"`
+	ItemsChanged []ItemChange         `json:"items_changed,omitempty"`
+}
+
+func (d OrderDiff) Empty() bool {
+	return len(d.Fields) == 0 && len(d.Delivery) == 0 && len(d.Payment) == 0 &&
+		len(d.ItemsAdded) == 0 && len(d.ItemsRemoved) == 0 && len(d.ItemsChanged) == 0
+}
+
+// Orders сравнивает две версии заказа. Товары сопоставляются сначала по rid,
+// затем оставшиеся — по chrt_id. Суммы сравниваются и выводятся в основных
+// единицах валюты своей версии.
+func Orders(from, to structs.Order) OrderDiff {
+	fromExp, toExp := from.Payment.Exponent(), to.Payment.Exponent()
+	d := OrderDiff{
+		Fields:   scalars(from, to, fromExp, toExp),
+		Delivery: scalars(from.Delivery, to.Delivery, fromExp, toExp),
+		Payment:  scalars(from.Payment, to.Payment, fromExp, toExp),
+	}
+
+	matchedFrom := make([]bool, len(from.Items))
+	matchedTo := make([]int, len(to.Items))
+	for i := range matchedTo {
+		matchedTo[i] = -1
+	}
+	match := func(key func(structs.Items) string) {
+		byKey := make(map[string][]int)
+		for i, it := range from.Items {
+			if k := key(it); k != "" && !matchedFrom[i] {
+				byKey[k] = append(byKey[k], i)
+			}
+		}
+		for j, it := range to.Items {
+			k := key(it)
+			if k == "" || matchedTo[j] >= 0 || len(byKey[k]) == 0 {
+				continue
+			}
+			i := byKey[k][0]
+			byKey[k] = byKey[k][1:]
+			matchedFrom[i] = true
+			matchedTo[j] = i
+		}
+	}
+	match(func(it structs.Items) string { return it.Rid })
+	match(func(it structs.Items) string {
+		if it.ChartID == 0 {
+			return ""
+		}
+		return strconv.FormatInt(it.ChartID, 10)
+	})
+
+	for j, it := range to.Items {
+		i := matchedTo[j]
+		if i < 0 {
+			d.ItemsAdded = append(d.ItemsAdded, structs.PricedItem{Items: it, Exp: toExp})
+			continue
+		}
+		if changes := scalars(from.Items[i], it, fromExp, toExp); len(changes) > 0 {
+			d.ItemsChanged = append(d.ItemsChanged, ItemChange{Key: itemKey(from.Items[i], it), Changes: changes})
+		}
+	}
+	for i, it := range from.Items {
+		if !matchedFrom[i] {
+			d.ItemsRemoved = append(d.ItemsRemoved, structs.PricedItem{Items: it, Exp: fromExp})
+		}
+	}
+	return d
+}
+
+func itemKey(from, to structs.Items) string {
+	if from.Rid != "" && from.Rid == to.Rid {
+		return "rid:" + from.Rid
+	}
+	return "chrt_id:" + strconv.FormatInt(from.ChartID, 10)
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// scalars сравнивает скалярные поля двух структур одного типа; вложенные
+// структуры (кроме time.Time) и слайсы пропускаются. Суммы становятся
+// structs.Amount с числом знаков валюты своей версии.
+func scalars(from, to any, fromExp, toExp int) []Change {
+	fv, tv := reflect.ValueOf(from), reflect.ValueOf(to)
+	t := fv.Type()
+
+	var changes []Change
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		switch f.Type.Kind() {
+		case reflect.Struct:
+			if f.Type != timeType {
+				continue
+			}
+		case reflect.Slice, reflect.Map, reflect.Pointer:
+			continue
+		}
+		a, b := fv.Field(i).Interface(), tv.Field(i).Interface()
+		if at, ok := a.(time.Time); ok && at.Equal(b.(time.Time)) {
+			continue
+		}
+		if am, ok := a.(structs.Money); ok {
+			a, b = structs.Amount{Value: am, Exp: fromExp}, structs.Amount{Value: b.(structs.Money), Exp: toExp}
+		}
+		if a == b {
+			continue
+		}
+		changes = append(changes, Change{Field: jsonName(f), From: a, To: b})
+	}
+	return changes
+}
+
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
@@ -0,0 +1,83 @@
+package diff
+
+import (
+	"testing"
+
+	"github.com/CodenSell/WB_test_level0/internal/structs"
+)
+
+func TestOrders_NoChanges(t *testing.T) {
+	o := structs.Order{OrderUID: "u1", Items: []structs.Items{{ChartID: 1, Rid: "r1", Name: "Mask"}}}
+	if d := Orders(o, o); !d.Empty() {
+		t.Fatalf("expected empty diff, got %+v", d)
+	}
+}
+
+func TestOrders_FieldsAndItems(t *testing.T) {
+	from := structs.Order{
+		OrderUID:    "u1",
+		TrackNumber: "OLD",
+		Delivery:    structs.Delivery{City: "Moscow"},
+		Payment:     structs.Payment{Amount: 100, Currency: "USD"},
+		Items: []structs.Items{
+			{ChartID: 1, Rid: "r1", Name: "Mask", Price: 10},
+			{ChartID: 2, Rid: "r2", Name: "Brush"},
+			{ChartID: 3, Name: "Cream", Status: 202},
+		},
+	}
+	to := structs.Order{
+		OrderUID:    "u1",
+		TrackNumber: "NEW",
+		Delivery:    structs.Delivery{City: "Kazan"},
+		Payment:     structs.Payment{Amount: 150, Currency: "USD"},
+		Items: []structs.Items{
+			{ChartID: 1, Rid: "r1", Name: "Mask", Price: 12},
+			{ChartID: 3, Rid: "r3", Name: "Cream", Status: 203},
+			{ChartID: 4, Rid: "r4", Name: "Comb"},
+		},
+	}
+
+	d := Orders(from, to)
+
+	if len(d.Fields) != 1 || d.Fields[0] != (Change{Field: "track_number", From: "OLD", To: "NEW"}) {
+		t.Fatalf("bad fields: %+v", d.Fields)
+	}
+	if len(d.Delivery) != 1 || d.Delivery[0].Field != "city" {
+		t.Fatalf("bad delivery: %+v", d.Delivery)
+	}
+	if len(d.Payment) != 1 || d.Payment[0] != (Change{Field: "amount", From: structs.Amount{Value: 100, Exp: 2}, To: structs.Amount{Value: 150, Exp: 2}}) {
+		t.Fatalf("bad payment: %+v", d.Payment)
+	}
+	if len(d.ItemsAdded) != 1 || d.ItemsAdded[0].ChartID != 4 {
+		t.Fatalf("bad added: %+v", d.ItemsAdded)
+	}
+	if len(d.ItemsRemoved) != 1 || d.ItemsRemoved[0].Rid != "r2" {
+		t.Fatalf("bad removed: %+v", d.ItemsRemoved)
+	}
+	if len(d.ItemsChanged) != 2 {
+		t.Fatalf("expected 2 changed items, got %+v", d.ItemsChanged)
+	}
+	if d.ItemsChanged[0].Key != "rid:r1" || d.ItemsChanged[0].Changes[0].Field != "price" {
+		t.Fatalf("bad change by rid: %+v", d.ItemsChanged[0])
+	}
+	// у chrt_id 3 появился rid, поэтому сопоставление идёт по chrt_id
+	if d.ItemsChanged[1].Key != "chrt_id:3" || len(d.ItemsChanged[1].Changes) != 2 {
+		t.Fatalf("bad change by chrt_id: %+v", d.ItemsChanged[1])
+	}
+}
+
+func TestOrders_AmountsInOrderCurrency(t *testing.T) {
+	from := structs.Order{Payment: structs.Payment{Amount: 1817, Currency: "JPY"}}
+	to := structs.Order{Payment: structs.Payment{Amount: 1817, Currency: "BHD"}}
+
+	d := Orders(from, to)
+	var amount Change
+	for _, c := range d.Payment {
+		if c.Field == "amount" {
+			amount = c
+		}
+	}
+	if amount.From == nil || amount.From.(structs.Amount).String() != "1817" || amount.To.(structs.Amount).String() != "1.817" {
+		t.Fatalf("amount must be shown in each version's currency: %+v", d.Payment)
+	}
+}
@@ -0,0 +1,58 @@
+package storage
+
+import "github.com/CodenSell/WB_test_level0/internal/structs"
+
+// LookupKey — вторичный ключ, по которому ищут заказ без order_uid.
+type LookupKey string
+
+const (
+	ByTrack       LookupKey = "track"       // orders.track_number
+	ByTransaction LookupKey = "transaction" // payments.transaction
+	ByRid         LookupKey = "rid"         // items.rid
+	ByCustomer    LookupKey = "customer"    // orders.customer_id
+)
+
+var LookupKeys = []LookupKey{ByTrack, ByTransaction, ByRid, ByCustomer}
+
+// MaxLookupOrders — сколько заказов, начиная с самых новых, отдаёт поиск по
+// одному значению ключа; остальные заказы покупателя — через список с фильтром.
+const MaxLookupOrders = 1000
+
+// Values — непустые значения ключа k у заказа; у rid их столько, сколько товаров.
+func (k LookupKey) Values(o *structs.Order) []string {
+	var vals []string
+	add := func(v string) {
+		if v == "" {
+			return
+		}
+		for _, have := range vals {
+			if have == v {
+				return
+			}
+		}
+		vals = append(vals, v)
+	}
+	switch k {
+	case ByTrack:
+		add(o.TrackNumber)
+	case ByTransaction:
+		add(o.Payment.Transaction)
+	case ByCustomer:
+		add(o.CustomerID)
+	case ByRid:
+		for i := range o.Items {
+			add(o.Items[i].Rid)
+		}
+	}
+	return vals
+}
+
+// Has сообщает, что у заказа есть значение value ключа k.
+func (k LookupKey) Has(o *structs.Order, value string) bool {
+	for _, v := range k.Values(o) {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,310 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/CodenSell/WB_test_level0/internal/storage"
+	"github.com/CodenSell/WB_test_level0/internal/structs"
+	"github.com/lib/pq"
+)
+
+// лимит числа параметров в одном запросе Postgres
+const maxParams = 65535
+
+// UpsertOrders пишет пачку заказов одной транзакцией: по одному многострочному
+// INSERT на таблицу. Повторы uid применяются по порядку, как если бы записи
+// шли по одной. Записи с уже применённым MessageID пропускаются с результатом
+// storage.ErrDuplicate, устаревшие — с storage.ErrStale; MessageID устаревших
+// тоже сохраняется, так что их повтор придёт как дубликат.
+func (r *Repository) UpsertOrders(ctx context.Context, writes []storage.OrderWrite) (results []error, err error) {
+	results = make([]error, len(writes))
+	if len(writes) == 0 {
+		return results, nil
+	}
+
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var idRows [][]any
+	for _, w := range writes {
+		if w.Meta.MessageID != "" {
+			idRows = append(idRows, []any{w.Meta.MessageID, w.Order.OrderUID})
+		}
+	}
+	fresh, err := queryStrings(ctx, tx, `INSERT INTO processed_messages (message_id, order_uid) VALUES `,
+		` ON CONFLICT (message_id) DO NOTHING RETURNING message_id`, idRows)
+	if err != nil {
+		return nil, err
+	}
+
+	// повторы uid в пачке пишутся по порядку, отдельными раундами в той же
+	// транзакции: как при записи по одному, каждая принятая версия получает свою
+	// ревизию. Запись с меньшей версией, чем у более ранней в пачке, или без
+	// версии после неё устарела заранее.
+	var rounds [][]int
+	latest := make(map[string]int64, len(writes))
+	seen := make(map[string]int, len(writes))
+	for i, w := range writes {
+		if id := w.Meta.MessageID; id != "" && !fresh[id] {
+			results[i] = storage.ErrDuplicate
+			continue
+		}
+		uid := w.Order.OrderUID
+		if v, ok := latest[uid]; ok && (w.Meta.Version == 0 || w.Meta.Version < v) {
+			results[i] = storage.ErrStale
+			continue
+		}
+		latest[uid] = max(latest[uid], w.Meta.Version)
+		n := seen[uid]
+		seen[uid] = n + 1
+		if n == len(rounds) {
+			rounds = append(rounds, nil)
+		}
+		rounds[n] = append(rounds[n], i)
+	}
+
+	for _, round := range rounds {
+		if err = upsertRound(ctx, tx, writes, round, results); err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	return results, err
+}
+
+// upsertRound пишет записи round (uid в раунде не повторяются) и отмечает
+// в results те, что БД отклонила как устаревшие.
+func upsertRound(ctx context.Context, tx *sql.Tx, writes []storage.OrderWrite, round []int, results []error) error {
+	orderRows := make([][]any, 0, len(round))
+	for _, i := range round {
+		w := writes[i]
+		o := w.Order
+		orderRows = append(orderRows, []any{o.OrderUID, o.TrackNumber, o.Entry, o.Localization, o.InternalSignature,
+			o.CustomerID, o.DeliveryService, o.ShardKey, o.StorageID, nullTime(o.DateCreated), o.OofShard, w.Meta.Version})
+	}
+
+	applied, err := queryRevisions(ctx, tx, `
+		INSERT INTO orders (order_uid, track_number, entry, locale, internal_signature,
+		                    customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard, version)
+		VALUES `, `
+		ON CONFLICT (order_uid) DO UPDATE SET
+		    track_number=EXCLUDED.track_number,
+		    entry=EXCLUDED.entry,
+		    locale=EXCLUDED.locale,
+		    internal_signature=EXCLUDED.internal_signature,
+		    customer_id=EXCLUDED.customer_id,
+		    delivery_service=EXCLUDED.delivery_service,
+		    shardkey=EXCLUDED.shardkey,
+		    sm_id=EXCLUDED.sm_id,
+		    date_created=EXCLUDED.date_created,
+		    oof_shard=EXCLUDED.oof_shard,
+		    version=GREATEST(orders.version, EXCLUDED.version),
+		    revision=orders.revision + 1,
+		    updated_at=now()
+		WHERE EXCLUDED.version <> 0 AND orders.version <= EXCLUDED.version
+		RETURNING order_uid, revision
+	`, orderRows)
+	if err != nil {
+		return err
+	}
+
+	uids := make([]string, 0, len(applied))
+	deliveryRows := make([][]any, 0, len(applied))
+	paymentRows := make([][]any, 0, len(applied))
+	revisions := make([]revisionRow, 0, len(applied))
+	var itemRows [][]any
+	for _, i := range round {
+		o := writes[i].Order
+		revision, ok := applied[o.OrderUID]
+		if !ok {
+			results[i] = storage.ErrStale
+			continue
+		}
+		uids = append(uids, o.OrderUID)
+		revisions = append(revisions, revisionRow{order: o, revision: revision, meta: writes[i].Meta})
+		deliveryRows = append(deliveryRows, []any{o.OrderUID, o.Delivery.Name, o.Delivery.Phone, o.Delivery.ZIP, o.Delivery.City,
+			o.Delivery.Address, o.Delivery.Region, o.Delivery.Email})
+		paymentRows = append(paymentRows, []any{o.OrderUID, o.Payment.Transaction, o.Payment.RequestID, o.Payment.Currency, o.Payment.Provider,
+			o.Payment.Amount, nullTime(o.Payment.PaymentDT), o.Payment.Bank, o.Payment.DeliveryCost,
+			o.Payment.GoodsTotal, o.Payment.CustomFee})
+		itemRows = appendItemRows(itemRows, o)
+	}
+	if len(uids) == 0 {
+		return nil
+	}
+
+	err = insertRows(ctx, tx, `
+		INSERT INTO deliveries (order_uid, name, phone, zip, city, address, region, email)
+		VALUES `, `
+		ON CONFLICT (order_uid) DO UPDATE SET
+		    name=EXCLUDED.name,
+		    phone=EXCLUDED.phone,
+		    zip=EXCLUDED.zip,
+		    city=EXCLUDED.city,
+		    address=EXCLUDED.address,
+		    region=EXCLUDED.region,
+		    email=EXCLUDED.email
+	`, deliveryRows)
+	if err != nil {
+		return err
+	}
+
+	err = insertRows(ctx, tx, `
+		INSERT INTO payments (order_uid, transaction, request_id, currency, provider, amount,
+		                      payment_dt, bank, delivery_cost, goods_total, custom_fee)
+		VALUES `, `
+		ON CONFLICT (order_uid) DO UPDATE SET
+		    transaction=EXCLUDED.transaction,
+		    request_id=EXCLUDED.request_id,
+		    currency=EXCLUDED.currency,
+		    provider=EXCLUDED.provider,
+		    amount=EXCLUDED.amount,
+		    payment_dt=EXCLUDED.payment_dt,
+		    bank=EXCLUDED.bank,
+		    delivery_cost=EXCLUDED.delivery_cost,
+		    goods_total=EXCLUDED.goods_total,
+		    custom_fee=EXCLUDED.custom_fee
+	`, paymentRows)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, `DELETE FROM items WHERE order_uid = ANY($1)`, pq.Array(uids)); err != nil {
+		return err
+	}
+	if err = insertItems(ctx, tx, itemRows); err != nil {
+		return err
+	}
+
+	return insertRevisions(ctx, tx, revisions)
+}
+
+// appendItemRows добавляет к rows строки товаров заказа для insertItems.
+func appendItemRows(rows [][]any, o *structs.Order) [][]any {
+	for _, it := range o.Items {
+		rows = append(rows, []any{o.OrderUID, it.ChartID, it.TrackNumber, it.Price, it.Rid,
+			it.Name, it.Sale, it.Size, it.TotalPrice, it.NomenclatureID, it.Brand, it.Status})
+	}
+	return rows
+}
+
+// insertItems пишет товары многострочными INSERT.
+func insertItems(ctx context.Context, tx *sql.Tx, rows [][]any) error {
+	return insertRows(ctx, tx, `
+		INSERT INTO items (order_uid, chrt_id, track_number, price, rid, name, sale, size,
+		                   total_price, nm_id, brand, status)
+		VALUES `, ``, rows)
+}
+
+// queryStrings выполняет многострочный INSERT ... RETURNING и собирает
+// множество значений первой колонки.
+func queryStrings(ctx context.Context, tx *sql.Tx, prefix, suffix string, rows [][]any) (map[string]bool, error) {
+	out := make(map[string]bool, len(rows))
+	for _, q := range valuesQueries(prefix, suffix, rows) {
+		res, err := tx.QueryContext(ctx, q.query, q.args...)
+		if err != nil {
+			return nil, err
+		}
+		for res.Next() {
+			var v string
+			if err := res.Scan(&v); err != nil {
+				res.Close()
+				return nil, err
+			}
+			out[v] = true
+		}
+		err = res.Err()
+		res.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// queryRevisions выполняет многострочный INSERT ... RETURNING order_uid, revision.
+func queryRevisions(ctx context.Context, tx *sql.Tx, prefix, suffix string, rows [][]any) (map[string]int, error) {
+	out := make(map[string]int, len(rows))
+	for _, q := range valuesQueries(prefix, suffix, rows) {
+		res, err := tx.QueryContext(ctx, q.query, q.args...)
+		if err != nil {
+			return nil, err
+		}
+		for res.Next() {
+			var uid string
+			var revision int
+			if err := res.Scan(&uid, &revision); err != nil {
+				res.Close()
+				return nil, err
+			}
+			out[uid] = revision
+		}
+		err = res.Err()
+		res.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// insertRows выполняет prefix + VALUES (...),(...) + suffix, разбивая строки на
+// запросы так, чтобы не превысить лимит параметров.
+func insertRows(ctx context.Context, tx *sql.Tx, prefix, suffix string, rows [][]any) error {
+	for _, q := range valuesQueries(prefix, suffix, rows) {
+		if _, err := tx.ExecContext(ctx, q.query, q.args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type valuesQuery struct {
+	query string
+	args  []any
+}
+
+func valuesQueries(prefix, suffix string, rows [][]any) []valuesQuery {
+	if len(rows) == 0 {
+		return nil
+	}
+	cols := len(rows[0])
+	per := maxParams / cols
+
+	var out []valuesQuery
+	for start := 0; start < len(rows); start += per {
+		chunk := rows[start:min(start+per, len(rows))]
+
+		var b strings.Builder
+		args := make([]any, 0, len(chunk)*cols)
+		b.WriteString(prefix)
+		for i, row := range chunk {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			b.WriteString("(")
+			for j := range row {
+				if j > 0 {
+					b.WriteString(",")
+				}
+				fmt.Fprintf(&b, "$%d", len(args)+j+1)
+			}
+			b.WriteString(")")
+			args = append(args, row...)
+		}
+		b.WriteString(suffix)
+		out = append(out, valuesQuery{query: b.String(), args: args})
+	}
+	return out
+}
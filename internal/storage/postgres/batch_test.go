@@ -0,0 +1,219 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/CodenSell/WB_test_level0/internal/storage"
+	"github.com/CodenSell/WB_test_level0/internal/structs"
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+func batchOrder(uid string, items ...structs.Items) *structs.Order {
+	return &structs.Order{
+		OrderUID:    uid,
+		TrackNumber: "WBTR",
+		DateCreated: time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC),
+		Delivery:    structs.Delivery{Name: "N", Phone: "+1", Email: "a@b.c"},
+		Payment:     structs.Payment{Transaction: uid, Currency: "USD", Amount: 10},
+		Items:       items,
+	}
+}
+
+func TestUpsertOrders_OK(t *testing.T) {
+	r, mock, done := mustRepo(t)
+	defer done()
+
+	u1 := batchOrder("u1", structs.Items{ChartID: 1, Name: "Mask"}, structs.Items{ChartID: 2, Name: "Brush"})
+	u2 := batchOrder("u2", structs.Items{ChartID: 3, Name: "Cream"})
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12),($13,`) + `(?s).*` + regexp.QuoteMeta(`ON CONFLICT (order_uid) DO UPDATE`)).
+		WillReturnRows(sqlmock.NewRows([]string{"order_uid", "revision"}).AddRow("u1", 3).AddRow("u2", 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO deliveries`) + `(?s).*` + regexp.QuoteMeta(`($1,$2,$3,$4,$5,$6,$7,$8),($9,`)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO payments`) + `(?s).*` + regexp.QuoteMeta(`($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11),($12,`)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM items WHERE order_uid = ANY($1)`)).
+		WithArgs(pq.Array([]string{"u1", "u2"})).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO items`)+`(?s).*`+regexp.QuoteMeta(`,($25,$26,$27,$28,$29,$30,$31,$32,$33,$34,$35,$36)`)).
+		WithArgs(
+			"u1", int64(1), "", 0, "", "Mask", 0, "", int64(0), int64(0), "", 0,
+			"u1", int64(2), "", 0, "", "Brush", 0, "", int64(0), int64(0), "", 0,
+			"u2", int64(3), "", 0, "", "Cream", 0, "", int64(0), int64(0), "", 0,
+		).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO order_revisions`)).
+		WithArgs(
+			"u1", 3, sqlmock.AnyArg(), "", "", "", int64(0), sqlmock.AnyArg(),
+			"u2", 1, sqlmock.AnyArg(), "", "", "", int64(0), sqlmock.AnyArg(),
+		).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	writes := []storage.OrderWrite{{Order: u1}, {Order: u2}}
+	results, err := r.UpsertOrders(context.Background(), writes)
+	if err != nil {
+		t.Fatalf("UpsertOrders err: %v", err)
+	}
+	for i, res := range results {
+		if res != nil {
+			t.Fatalf("result %d: %v", i, res)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestUpsertOrders_RollbackOnError(t *testing.T) {
+	r, mock, done := mustRepo(t)
+	defer done()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO orders`)).WillReturnError(context.DeadlineExceeded)
+	mock.ExpectRollback()
+
+	if _, err := r.UpsertOrders(context.Background(), []storage.OrderWrite{{Order: batchOrder("u1")}}); err == nil {
+		t.Fatalf("expected error")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestUpsertOrders_SkipsDuplicates(t *testing.T) {
+	r, mock, done := mustRepo(t)
+	defer done()
+
+	writes := []storage.OrderWrite{
+		{Order: batchOrder("u1", structs.Items{ChartID: 1, Name: "Mask"}), Meta: storage.Meta{MessageID: "orders/0/1"}},
+		{Order: batchOrder("u2", structs.Items{ChartID: 2, Name: "Cream"}), Meta: storage.Meta{MessageID: "orders/0/2"}},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO processed_messages (message_id, order_uid) VALUES ($1,$2),($3,$4) ON CONFLICT (message_id) DO NOTHING RETURNING message_id`)).
+		WithArgs("orders/0/1", "u1", "orders/0/2", "u2").
+		WillReturnRows(sqlmock.NewRows([]string{"message_id"}).AddRow("orders/0/2"))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO orders`)).WillReturnRows(sqlmock.NewRows([]string{"order_uid", "revision"}).AddRow("u2", 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO deliveries`)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO payments`)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM items`)).
+		WithArgs(pq.Array([]string{"u2"})).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO items`)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO order_revisions`)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	results, err := r.UpsertOrders(context.Background(), writes)
+	if err != nil {
+		t.Fatalf("UpsertOrders err: %v", err)
+	}
+	if !errors.Is(results[0], storage.ErrDuplicate) || results[1] != nil {
+		t.Fatalf("bad results: %v", results)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestUpsertOrders_Versions(t *testing.T) {
+	r, mock, done := mustRepo(t)
+	defer done()
+
+	writes := []storage.OrderWrite{
+		{Order: batchOrder("u1", structs.Items{Name: "new"}), Meta: storage.Meta{Version: 20}},
+		{Order: batchOrder("u1", structs.Items{Name: "old"}), Meta: storage.Meta{Version: 10}},
+		{Order: batchOrder("u2", structs.Items{Name: "x"}), Meta: storage.Meta{Version: 5}},
+		// без версии после записи того же uid — только вставка, заранее устарела
+		{Order: batchOrder("u2", structs.Items{Name: "backfill"}), Meta: storage.Meta{}},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO orders`)).
+		WithArgs(
+			"u1", "WBTR", "", "", "", "", "", "", 0, time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC), "", int64(20),
+			"u2", "WBTR", "", "", "", "", "", "", 0, time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC), "", int64(5),
+		).
+		WillReturnRows(sqlmock.NewRows([]string{"order_uid", "revision"}).AddRow("u1", 2))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO deliveries`)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO payments`)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM items`)).
+		WithArgs(pq.Array([]string{"u1"})).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO items`)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO order_revisions`)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	results, err := r.UpsertOrders(context.Background(), writes)
+	if err != nil {
+		t.Fatalf("UpsertOrders err: %v", err)
+	}
+	if results[0] != nil || !errors.Is(results[1], storage.ErrStale) || !errors.Is(results[2], storage.ErrStale) ||
+		!errors.Is(results[3], storage.ErrStale) {
+		t.Fatalf("bad results: %v", results)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestUpsertOrders_RepeatedUIDInRounds(t *testing.T) {
+	r, mock, done := mustRepo(t)
+	defer done()
+
+	writes := []storage.OrderWrite{
+		{Order: batchOrder("u1", structs.Items{Name: "first"}), Meta: storage.Meta{Version: 10}},
+		{Order: batchOrder("u2", structs.Items{Name: "x"}), Meta: storage.Meta{Version: 10}},
+		{Order: batchOrder("u1", structs.Items{Name: "second"}), Meta: storage.Meta{Version: 10}},
+	}
+	created := time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO orders`)).
+		WithArgs(
+			"u1", "WBTR", "", "", "", "", "", "", 0, created, "", int64(10),
+			"u2", "WBTR", "", "", "", "", "", "", 0, created, "", int64(10),
+		).
+		WillReturnRows(sqlmock.NewRows([]string{"order_uid", "revision"}).AddRow("u1", 1).AddRow("u2", 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO deliveries`)).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO payments`)).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM items`)).
+		WithArgs(pq.Array([]string{"u1", "u2"})).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO items`)).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO order_revisions`)).WillReturnResult(sqlmock.NewResult(0, 2))
+
+	// вторая запись u1 с той же версией — следующим раундом и своей ревизией
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO orders`)).
+		WithArgs("u1", "WBTR", "", "", "", "", "", "", 0, created, "", int64(10)).
+		WillReturnRows(sqlmock.NewRows([]string{"order_uid", "revision"}).AddRow("u1", 2))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO deliveries`)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO payments`)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM items`)).
+		WithArgs(pq.Array([]string{"u1"})).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO items`)).
+		WithArgs("u1", int64(0), "", 0, "", "second", 0, "", int64(0), int64(0), "", 0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO order_revisions`)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	results, err := r.UpsertOrders(context.Background(), writes)
+	if err != nil {
+		t.Fatalf("UpsertOrders err: %v", err)
+	}
+	for i, res := range results {
+		if res != nil {
+			t.Fatalf("write %d: %v, want every write applied", i, res)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/CodenSell/WB_test_level0/internal/storage"
+	"github.com/CodenSell/WB_test_level0/internal/structs"
+)
+
+type revisionRow struct {
+	order    *structs.Order
+	revision int
+	meta     storage.Meta
+}
+
+// insertRevisions сохраняет снимки принятых ревизий заказов в order_revisions.
+func insertRevisions(ctx context.Context, tx *sql.Tx, revisions []revisionRow) error {
+	rows := make([][]any, 0, len(revisions))
+	for _, rev := range revisions {
+		snapshot, err := json.Marshal(rev.order)
+		if err != nil {
+			return err
+		}
+		receivedAt := rev.meta.ReceivedAt
+		if receivedAt.IsZero() {
+			receivedAt = time.Now()
+		}
+		rows = append(rows, []any{rev.order.OrderUID, rev.revision, snapshot, rev.meta.Source,
+			rev.meta.SourceRef, rev.meta.MessageID, rev.meta.Version, receivedAt.UTC()})
+	}
+	return insertRows(ctx, tx, `
+		INSERT INTO order_revisions (order_uid, revision, snapshot, source, source_ref,
+		                             message_id, version, received_at)
+		VALUES `, ``, rows)
+}
+
+// ListRevisions возвращает все ревизии заказа по возрастанию номера.
+func (r *Repository) ListRevisions(ctx context.Context, orderUID string) ([]storage.Revision, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT order_uid, revision, snapshot, source, source_ref, message_id, version,
+		       received_at, recorded_at
+		FROM order_revisions WHERE order_uid=$1
+		ORDER BY revision
+	`, orderUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []storage.Revision
+	for rows.Next() {
+		rev, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, *rev)
+	}
+	return revisions, rows.Err()
+}
+
+// ListRevisionInfos возвращает ревизии заказа по возрастанию номера без
+// снимков — для списка истории, где JSONB заказа не нужен.
+func (r *Repository) ListRevisionInfos(ctx context.Context, orderUID string) ([]storage.RevisionInfo, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT order_uid, revision, source, source_ref, message_id, version,
+		       received_at, recorded_at
+		FROM order_revisions WHERE order_uid=$1
+		ORDER BY revision
+	`, orderUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var infos []storage.RevisionInfo
+	for rows.Next() {
+		var info storage.RevisionInfo
+		if err := rows.Scan(&info.OrderUID, &info.Revision, &info.Source, &info.SourceRef,
+			&info.MessageID, &info.Version, &info.ReceivedAt, &info.RecordedAt); err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, rows.Err()
+}
+
+// GetRevision возвращает одну ревизию заказа; sql.ErrNoRows, если её нет.
+func (r *Repository) GetRevision(ctx context.Context, orderUID string, revision int) (*storage.Revision, error) {
+	row := r.db.QueryRowContext(ctx, `
+		SELECT order_uid, revision, snapshot, source, source_ref, message_id, version,
+		       received_at, recorded_at
+		FROM order_revisions WHERE order_uid=$1 AND revision=$2
+	`, orderUID, revision)
+	return scanRevision(row)
+}
+
+func scanRevision(row interface{ Scan(...any) error }) (*storage.Revision, error) {
+	var rev storage.Revision
+	var snapshot []byte
+	if err := row.Scan(&rev.OrderUID, &rev.Revision, &snapshot, &rev.Source, &rev.SourceRef,
+		&rev.MessageID, &rev.Version, &rev.ReceivedAt, &rev.RecordedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(snapshot, &rev.Order); err != nil {
+		return nil, err
+	}
+	return &rev, nil
+}
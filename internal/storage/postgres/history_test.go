@@ -0,0 +1,65 @@
+package postgres
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestListRevisions_OK(t *testing.T) {
+	r, mock, done := mustRepo(t)
+	defer done()
+
+	received := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM order_revisions WHERE order_uid=$1`)).
+		WithArgs("u1").
+		WillReturnRows(sqlmock.NewRows([]string{
+			"order_uid", "revision", "snapshot", "source", "source_ref", "message_id", "version",
+			"received_at", "recorded_at",
+		}).
+			AddRow("u1", 1, []byte(`{"order_uid":"u1","track_number":"OLD"}`), "kafka", "orders/0/1", "orders/0/1", int64(10), received, received).
+			AddRow("u1", 2, []byte(`{"order_uid":"u1","track_number":"NEW"}`), "http", "10.0.0.1:5555", "", int64(20), received, received))
+
+	revs, err := r.ListRevisions(context.Background(), "u1")
+	if err != nil {
+		t.Fatalf("ListRevisions err: %v", err)
+	}
+	if len(revs) != 2 || revs[0].Order.TrackNumber != "OLD" || revs[1].Order.TrackNumber != "NEW" || revs[1].Source != "http" {
+		t.Fatalf("bad revisions: %+v", revs)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestListRevisionInfos_NoSnapshots(t *testing.T) {
+	r, mock, done := mustRepo(t)
+	defer done()
+
+	received := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta(`
+		SELECT order_uid, revision, source, source_ref, message_id, version,
+		       received_at, recorded_at
+		FROM order_revisions WHERE order_uid=$1`)).
+		WithArgs("u1").
+		WillReturnRows(sqlmock.NewRows([]string{
+			"order_uid", "revision", "source", "source_ref", "message_id", "version",
+			"received_at", "recorded_at",
+		}).
+			AddRow("u1", 1, "kafka", "orders/0/1", "orders/0/1", int64(10), received, received).
+			AddRow("u1", 2, "http", "10.0.0.1:5555", "", int64(20), received, received))
+
+	infos, err := r.ListRevisionInfos(context.Background(), "u1")
+	if err != nil {
+		t.Fatalf("ListRevisionInfos err: %v", err)
+	}
+	if len(infos) != 2 || infos[1].Revision != 2 || infos[1].Version != 20 || infos[1].Source != "http" {
+		t.Fatalf("bad revisions: %+v", infos)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
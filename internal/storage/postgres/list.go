@@ -0,0 +1,114 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/CodenSell/WB_test_level0/internal/storage"
+	"github.com/CodenSell/WB_test_level0/internal/structs"
+)
+
+const (
+	DefaultListLimit = 50
+	MaxListLimit     = 500
+)
+
+// createdKey — ключ сортировки по date_created, как в индексе orders_created_idx:
+// заказы без даты идут как самые старые.
+const createdKey = `COALESCE(o.date_created, '-infinity'::timestamptz)`
+
+// ListOrders возвращает страницу заказов по фильтру. Пагинация по ключу
+// (keyset): следующая страница начинается строго после q.After, поэтому
+// вставки между запросами не сдвигают страницы и глубокие страницы не дороже первой.
+func (r *Repository) ListOrders(ctx context.Context, q storage.OrderQuery) (*storage.OrderPage, error) {
+	if q.Sort.Field == "" {
+		q.Sort = storage.DefaultOrderSort
+	}
+	if q.Sort.Field != "date_created" && q.Sort.Field != "order_uid" {
+		return nil, fmt.Errorf("unknown sort %q", q.Sort.Field)
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	limit = min(limit, MaxListLimit)
+
+	var where []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	f := q.Filter
+	for _, c := range []struct{ col, val string }{
+		{"o.customer_id", f.CustomerID},
+		{"o.track_number", f.TrackNumber},
+		{"o.delivery_service", f.DeliveryService},
+		{"o.entry", f.Entry},
+		{"o.locale", f.Locale},
+		{"p.currency", f.Currency},
+		{"p.provider", f.Provider},
+		{"p.bank", f.Bank},
+	} {
+		if c.val != "" {
+			where = append(where, c.col+" = "+arg(c.val))
+		}
+	}
+	if !f.CreatedFrom.IsZero() {
+		where = append(where, "o.date_created >= "+arg(f.CreatedFrom))
+	}
+	if !f.CreatedTo.IsZero() {
+		where = append(where, "o.date_created < "+arg(f.CreatedTo))
+	}
+
+	op, dir := ">", "ASC"
+	if q.Sort.Desc {
+		op, dir = "<", "DESC"
+	}
+	order := "o.order_uid " + dir
+	if q.Sort.Field == "date_created" {
+		order = createdKey + " " + dir + ", " + order
+	}
+	if c := q.After; c != nil {
+		if q.Sort.Field == "date_created" {
+			where = append(where, fmt.Sprintf("(%s, o.order_uid) %s (COALESCE(%s::timestamptz, '-infinity'::timestamptz), %s)",
+				createdKey, op, arg(nullTime(c.DateCreated)), arg(c.OrderUID)))
+		} else {
+			where = append(where, "o.order_uid "+op+" "+arg(c.OrderUID))
+		}
+	}
+
+	query := selectOrders
+	if len(where) > 0 {
+		query += "WHERE " + strings.Join(where, " AND ") + "\n"
+	}
+	// лишняя строка показывает, есть ли следующая страница
+	query += fmt.Sprintf("ORDER BY %s\nLIMIT %d", order, limit+1)
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &storage.OrderPage{Orders: []structs.Order{}}
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		page.Orders = append(page.Orders, *o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Orders) > limit {
+		page.Orders = page.Orders[:limit]
+		page.Next = storage.CursorAfter(q.Sort, &page.Orders[limit-1]).Encode()
+	}
+	return page, nil
+}
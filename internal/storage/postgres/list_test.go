@@ -0,0 +1,98 @@
+package postgres
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/CodenSell/WB_test_level0/internal/storage"
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestListOrders_FiltersAndNextCursor(t *testing.T) {
+	r, mock, done := mustRepo(t)
+	defer done()
+
+	from := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta(selectOrders+
+		"WHERE o.customer_id = $1 AND p.currency = $2 AND o.date_created >= $3\n"+
+		"ORDER BY "+createdKey+" DESC, o.order_uid DESC\nLIMIT 3")).
+		WithArgs("cust", "USD", from).
+		WillReturnRows(sqlmock.NewRows(orderColumns).
+			AddRow(orderRow("u3", `[]`)...).
+			AddRow(orderRow("u2", `[]`)...).
+			AddRow(orderRow("u1", `[]`)...))
+
+	page, err := r.ListOrders(context.Background(), storage.OrderQuery{
+		Filter: storage.OrderFilter{CustomerID: "cust", Currency: "USD", CreatedFrom: from},
+		Limit:  2,
+	})
+	if err != nil {
+		t.Fatalf("ListOrders: %v", err)
+	}
+	if len(page.Orders) != 2 || page.Orders[1].OrderUID != "u2" || page.Next == "" {
+		t.Fatalf("bad page: %+v", page)
+	}
+
+	c, err := storage.DecodeCursor(page.Next, storage.DefaultOrderSort)
+	if err != nil || c.OrderUID != "u2" || !c.DateCreated.Equal(page.Orders[1].DateCreated) {
+		t.Fatalf("bad next cursor: %+v, %v", c, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet: %v", err)
+	}
+}
+
+func TestListOrders_AfterCursor(t *testing.T) {
+	r, mock, done := mustRepo(t)
+	defer done()
+
+	created := time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta(selectOrders+
+		"WHERE ("+createdKey+", o.order_uid) > (COALESCE($1::timestamptz, '-infinity'::timestamptz), $2)\n"+
+		"ORDER BY "+createdKey+" ASC, o.order_uid ASC\nLIMIT 51")).
+		WithArgs(created, "u2").
+		WillReturnRows(sqlmock.NewRows(orderColumns).AddRow(orderRow("u3", `[]`)...))
+
+	sort := storage.OrderSort{Field: "date_created"}
+	page, err := r.ListOrders(context.Background(), storage.OrderQuery{
+		Sort:  sort,
+		After: &storage.Cursor{Sort: sort.String(), DateCreated: created, OrderUID: "u2"},
+	})
+	if err != nil {
+		t.Fatalf("ListOrders: %v", err)
+	}
+	if len(page.Orders) != 1 || page.Next != "" {
+		t.Fatalf("expected the last page with one order, got %+v", page)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet: %v", err)
+	}
+}
+
+func TestListOrders_ByUIDWithoutDates(t *testing.T) {
+	r, mock, done := mustRepo(t)
+	defer done()
+
+	mock.ExpectQuery(regexp.QuoteMeta(selectOrders +
+		"WHERE o.order_uid < $1\nORDER BY o.order_uid DESC\nLIMIT 11")).
+		WithArgs("u5").
+		WillReturnRows(sqlmock.NewRows(orderColumns))
+
+	sort := storage.OrderSort{Field: "order_uid", Desc: true}
+	page, err := r.ListOrders(context.Background(), storage.OrderQuery{
+		Sort:  sort,
+		After: &storage.Cursor{Sort: sort.String(), OrderUID: "u5"},
+		Limit: 10,
+	})
+	if err != nil {
+		t.Fatalf("ListOrders: %v", err)
+	}
+	if page.Orders == nil || len(page.Orders) != 0 {
+		t.Fatalf("empty page must encode as an empty list: %+v", page)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet: %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/CodenSell/WB_test_level0/internal/storage"
+)
+
+// lookupConditions — условие поиска по каждому вторичному ключу; индексы —
+// в миграциях 0006_orders_listing и 0007_lookup_keys.
+var lookupConditions = map[storage.LookupKey]string{
+	storage.ByTrack:       `o.track_number = $1`,
+	storage.ByCustomer:    `o.customer_id = $1`,
+	storage.ByTransaction: `EXISTS (SELECT 1 FROM payments p WHERE p.order_uid = o.order_uid AND p.transaction = $1)`,
+	storage.ByRid:         `EXISTS (SELECT 1 FROM items i WHERE i.order_uid = o.order_uid AND i.rid = $1)`,
+}
+
+// FindOrderUIDs — uid заказов со значением value ключа key, сначала новые,
+// не больше storage.MaxLookupOrders.
+func (r *Repository) FindOrderUIDs(ctx context.Context, key storage.LookupKey, value string) ([]string, error) {
+	cond, ok := lookupConditions[key]
+	if !ok {
+		return nil, fmt.Errorf("unknown lookup key %q", key)
+	}
+	rows, err := r.db.QueryContext(ctx, `SELECT o.order_uid FROM orders o WHERE `+cond+`
+		ORDER BY `+createdKey+` DESC, o.order_uid DESC LIMIT $2`, value, storage.MaxLookupOrders)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var uids []string
+	for rows.Next() {
+		var uid string
+		if err := rows.Scan(&uid); err != nil {
+			return nil, err
+		}
+		uids = append(uids, uid)
+	}
+	return uids, rows.Err()
+}
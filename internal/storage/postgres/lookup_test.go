@@ -0,0 +1,30 @@
+package postgres
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/CodenSell/WB_test_level0/internal/storage"
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestFindOrderUIDs(t *testing.T) {
+	r, mock, done := mustRepo(t)
+	defer done()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT o.order_uid FROM orders o WHERE EXISTS (SELECT 1 FROM items i WHERE i.order_uid = o.order_uid AND i.rid = $1)`)).
+		WithArgs("rid-1", storage.MaxLookupOrders).
+		WillReturnRows(sqlmock.NewRows([]string{"order_uid"}).AddRow("u2").AddRow("u1"))
+
+	uids, err := r.FindOrderUIDs(context.Background(), storage.ByRid, "rid-1")
+	if err != nil || len(uids) != 2 || uids[0] != "u2" {
+		t.Fatalf("FindOrderUIDs: %v %v", uids, err)
+	}
+	if _, err := r.FindOrderUIDs(context.Background(), "email", "a@b.c"); err == nil {
+		t.Fatalf("expected error for unknown key")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet: %v", err)
+	}
+}
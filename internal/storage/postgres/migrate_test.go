@@ -0,0 +1,232 @@
+package postgres
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/CodenSell/WB_test_level0/internal/structs"
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestMigrations_Embedded(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("Migrations: %v", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatalf("no embedded migrations")
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Fatalf("migration #%d has version %d, want consecutive versions", i, m.Version)
+		}
+	}
+}
+
+// Миграция денег переводит суммы по тем же числам знаков, что и кодек JSON.
+func TestMigrations_CurrencyScales(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("Migrations: %v", err)
+	}
+	var m Migration
+	for _, m = range migrations {
+		if m.Name == "dates_and_money" {
+			break
+		}
+	}
+	when := regexp.MustCompile(`WHEN c IN \(([^)]*)\) THEN (\d+)`)
+	for name, script := range map[string]string{"up": m.Up, "down": m.Down} {
+		scales := make(map[string]int64)
+		for _, w := range when.FindAllStringSubmatch(script, -1) {
+			scale, _ := strconv.ParseInt(w[2], 10, 64)
+			for _, c := range strings.Split(w[1], ",") {
+				scales[strings.Trim(strings.TrimSpace(c), "'")] = scale
+			}
+		}
+		if len(scales) == 0 {
+			t.Fatalf("%s: no currency scales found", name)
+		}
+		for a := 'A'; a <= 'Z'; a++ {
+			for b := 'A'; b <= 'Z'; b++ {
+				for c := 'A'; c <= 'Z'; c++ {
+					code := string([]rune{a, b, c})
+					exp, ok := structs.CurrencyExponent(code)
+					want := int64(100)
+					if ok {
+						want = 1
+						for range exp {
+							want *= 10
+						}
+					}
+					got, listed := scales[code]
+					if !listed {
+						got = 100
+					}
+					if got != want {
+						t.Errorf("%s: %s scaled by %d, want %d", name, code, got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestParseMigrations_Rejects(t *testing.T) {
+	cases := map[string]fstest.MapFS{
+		"no down": {
+			"m/0001_init.up.sql": {Data: []byte("SELECT 1")},
+		},
+		"bad name": {
+			"m/init.up.sql":   {Data: []byte("SELECT 1")},
+			"m/init.down.sql": {Data: []byte("SELECT 1")},
+		},
+		"names differ": {
+			"m/0001_init.up.sql":    {Data: []byte("SELECT 1")},
+			"m/0001_other.down.sql": {Data: []byte("SELECT 1")},
+		},
+	}
+	for name, fsys := range cases {
+		if _, err := parseMigrations(fsys, "m"); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func expectMigrationLock(mock sqlmock.Sqlmock, applied ...int) {
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_lock($1)`)).
+		WithArgs(migrationLock).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`CREATE TABLE IF NOT EXISTS schema_migrations`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	rows := sqlmock.NewRows([]string{"version", "applied_at"})
+	for _, v := range applied {
+		rows.AddRow(v, time.Now())
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT version, applied_at FROM schema_migrations`)).WillReturnRows(rows)
+}
+
+func TestMigrateUp_AppliesPending(t *testing.T) {
+	r, mock, done := mustRepo(t)
+	defer done()
+
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("Migrations: %v", err)
+	}
+	var applied []int
+	for _, m := range migrations[:len(migrations)-1] {
+		applied = append(applied, m.Version)
+	}
+	last := migrations[len(migrations)-1]
+
+	expectMigrationLock(mock, applied...)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(last.Up)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO schema_migrations`)).
+		WithArgs(last.Version, last.Name).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_unlock($1)`)).
+		WithArgs(migrationLock).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	got, err := r.MigrateUp(context.Background())
+	if err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+	if len(got) != 1 || got[0].Version != last.Version {
+		t.Fatalf("expected only %d to be applied, got %+v", last.Version, got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet: %v", err)
+	}
+}
+
+func TestMigrateUp_RollsBackFailed(t *testing.T) {
+	r, mock, done := mustRepo(t)
+	defer done()
+
+	expectMigrationLock(mock)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`CREATE TABLE IF NOT EXISTS orders`)).WillReturnError(context.DeadlineExceeded)
+	mock.ExpectRollback()
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_unlock($1)`)).
+		WithArgs(migrationLock).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	got, err := r.MigrateUp(context.Background())
+	if err == nil || len(got) != 0 {
+		t.Fatalf("expected failure with nothing applied, got %+v, %v", got, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet: %v", err)
+	}
+}
+
+func TestMigrateDown_RevertsLatest(t *testing.T) {
+	r, mock, done := mustRepo(t)
+	defer done()
+
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("Migrations: %v", err)
+	}
+	last := migrations[len(migrations)-1]
+
+	expectMigrationLock(mock, 1, last.Version)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(last.Down)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM schema_migrations WHERE version = $1`)).
+		WithArgs(last.Version).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_unlock($1)`)).
+		WithArgs(migrationLock).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	got, err := r.MigrateDown(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("MigrateDown: %v", err)
+	}
+	if len(got) != 1 || got[0].Version != last.Version {
+		t.Fatalf("expected %d to be reverted, got %+v", last.Version, got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet: %v", err)
+	}
+}
+
+func TestMigrationStatus_ReadOnly(t *testing.T) {
+	r, mock, done := mustRepo(t)
+	defer done()
+
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("Migrations: %v", err)
+	}
+
+	// ни блокировки, ни CREATE TABLE: неожиданный запрос провалит тест
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT to_regclass('schema_migrations') IS NOT NULL`)).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT version, applied_at FROM schema_migrations`)).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, time.Now()))
+
+	got, err := r.MigrationStatus(context.Background())
+	if err != nil {
+		t.Fatalf("MigrationStatus: %v", err)
+	}
+	if len(got) != len(migrations) || !got[0].Applied || got[1].Applied {
+		t.Fatalf("unexpected status: %+v", got)
+	}
+
+	// таблицы ещё нет — всё в ожидании
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT to_regclass('schema_migrations') IS NOT NULL`)).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	got, err = r.MigrationStatus(context.Background())
+	if err != nil || len(got) != len(migrations) || got[0].Applied {
+		t.Fatalf("status without schema_migrations: %+v, %v", got, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet: %v", err)
+	}
+}
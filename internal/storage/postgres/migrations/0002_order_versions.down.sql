@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS processed_messages;
+
+ALTER TABLE orders DROP COLUMN IF EXISTS revision;
+ALTER TABLE orders DROP COLUMN IF EXISTS version;
@@ -0,0 +1,11 @@
+-- отдельные ALTER, а не колонки в CREATE TABLE 0001: так они добавятся и в
+-- базы, созданные ещё старым database/postgres/schema.sql; в базах, где
+-- колонки уже есть, IF NOT EXISTS ничего не делает
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS revision INT NOT NULL DEFAULT 1;
+
+CREATE TABLE IF NOT EXISTS processed_messages (
+  message_id TEXT PRIMARY KEY,
+  order_uid TEXT NOT NULL,
+  processed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS order_revisions (
+  order_uid TEXT NOT NULL REFERENCES orders(order_uid) ON DELETE CASCADE,
+  revision INT NOT NULL,
+  snapshot JSONB NOT NULL,
+  source TEXT NOT NULL DEFAULT '',
+  source_ref TEXT NOT NULL DEFAULT '',
+  message_id TEXT NOT NULL DEFAULT '',
+  version BIGINT NOT NULL DEFAULT 0,
+  received_at TIMESTAMPTZ NOT NULL,
+  recorded_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (order_uid, revision)
+);
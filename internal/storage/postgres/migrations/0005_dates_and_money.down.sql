@@ -0,0 +1,43 @@
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_currency_check;
+
+-- суммы обратно в основные единицы по тем же числам знаков, что и в up
+CREATE FUNCTION pg_temp.currency_scale(c text) RETURNS bigint
+LANGUAGE sql IMMUTABLE AS $$
+  SELECT CASE
+    WHEN c IN ('BIF', 'CLP', 'DJF', 'GNF', 'ISK', 'JPY', 'KMF', 'KRW', 'PYG', 'RWF', 'UGX', 'UYI', 'VND', 'VUV', 'XAF', 'XOF', 'XPF') THEN 1
+    WHEN c IN ('BHD', 'IQD', 'JOD', 'KWD', 'LYD', 'OMR', 'TND') THEN 1000
+    WHEN c IN ('CLF', 'UYW') THEN 10000
+    ELSE 100
+  END
+$$;
+
+UPDATE items i SET
+  price = i.price / k.scale,
+  total_price = i.total_price / k.scale
+FROM (SELECT o.order_uid, pg_temp.currency_scale(p.currency) AS scale
+      FROM orders o LEFT JOIN payments p USING (order_uid)) k
+WHERE k.order_uid = i.order_uid;
+
+UPDATE payments SET
+  amount = amount / pg_temp.currency_scale(currency),
+  delivery_cost = delivery_cost / pg_temp.currency_scale(currency),
+  goods_total = goods_total / pg_temp.currency_scale(currency),
+  custom_fee = custom_fee / pg_temp.currency_scale(currency);
+
+DROP FUNCTION pg_temp.currency_scale(text);
+
+ALTER TABLE items
+  ALTER COLUMN price TYPE INT USING price::int,
+  ALTER COLUMN total_price TYPE BIGINT;
+
+ALTER TABLE payments
+  ALTER COLUMN amount TYPE INT USING amount::int,
+  ALTER COLUMN delivery_cost TYPE INT USING delivery_cost::int,
+  ALTER COLUMN goods_total TYPE INT USING goods_total::int,
+  ALTER COLUMN custom_fee TYPE INT USING custom_fee::int;
+
+ALTER TABLE payments ALTER COLUMN payment_dt TYPE BIGINT
+  USING COALESCE(extract(epoch FROM payment_dt)::bigint, 0);
+
+ALTER TABLE orders ALTER COLUMN date_created TYPE TEXT
+  USING COALESCE(to_char(date_created AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"'), '');
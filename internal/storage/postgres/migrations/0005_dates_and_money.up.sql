@@ -0,0 +1,51 @@
+-- даты: строка RFC 3339 и unix-секунды → TIMESTAMPTZ; пустые значения → NULL
+ALTER TABLE orders ALTER COLUMN date_created TYPE TIMESTAMPTZ
+  USING CASE WHEN date_created ~ '^\d{4}-\d{2}-\d{2}' THEN date_created::timestamptz END;
+
+ALTER TABLE payments ALTER COLUMN payment_dt TYPE TIMESTAMPTZ
+  USING CASE WHEN payment_dt > 0 THEN to_timestamp(payment_dt) END;
+
+-- код валюты ISO 4217; старые строки без валюты не проверяются (NOT VALID),
+-- после их исправления: ALTER TABLE payments VALIDATE CONSTRAINT payments_currency_check
+UPDATE payments SET currency = upper(btrim(currency)) WHERE currency <> upper(btrim(currency));
+
+ALTER TABLE payments ADD CONSTRAINT payments_currency_check
+  CHECK (currency ~ '^[A-Z]{3}$') NOT VALID;
+
+-- деньги: целые основные единицы → BIGINT в минимальных единицах валюты
+-- заказа. Число знаков — как в structs.CurrencyExponent: не перечисленные
+-- здесь валюты (и неизвестные) — два знака.
+CREATE FUNCTION pg_temp.currency_scale(c text) RETURNS bigint
+LANGUAGE sql IMMUTABLE AS $$
+  SELECT CASE
+    WHEN c IN ('BIF', 'CLP', 'DJF', 'GNF', 'ISK', 'JPY', 'KMF', 'KRW', 'PYG', 'RWF', 'UGX', 'UYI', 'VND', 'VUV', 'XAF', 'XOF', 'XPF') THEN 1
+    WHEN c IN ('BHD', 'IQD', 'JOD', 'KWD', 'LYD', 'OMR', 'TND') THEN 1000
+    WHEN c IN ('CLF', 'UYW') THEN 10000
+    ELSE 100
+  END
+$$;
+
+ALTER TABLE payments
+  ALTER COLUMN amount TYPE BIGINT,
+  ALTER COLUMN delivery_cost TYPE BIGINT,
+  ALTER COLUMN goods_total TYPE BIGINT,
+  ALTER COLUMN custom_fee TYPE BIGINT;
+
+ALTER TABLE items
+  ALTER COLUMN price TYPE BIGINT,
+  ALTER COLUMN total_price TYPE BIGINT;
+
+UPDATE items i SET
+  price = i.price * k.scale,
+  total_price = i.total_price * k.scale
+FROM (SELECT o.order_uid, pg_temp.currency_scale(p.currency) AS scale
+      FROM orders o LEFT JOIN payments p USING (order_uid)) k
+WHERE k.order_uid = i.order_uid;
+
+UPDATE payments SET
+  amount = amount * pg_temp.currency_scale(currency),
+  delivery_cost = delivery_cost * pg_temp.currency_scale(currency),
+  goods_total = goods_total * pg_temp.currency_scale(currency),
+  custom_fee = custom_fee * pg_temp.currency_scale(currency);
+
+DROP FUNCTION pg_temp.currency_scale(text);
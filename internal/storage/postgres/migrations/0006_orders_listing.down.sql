@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS items_order_uid_idx;
+DROP INDEX IF EXISTS orders_track_number_idx;
+DROP INDEX IF EXISTS orders_customer_created_idx;
+DROP INDEX IF EXISTS orders_created_idx;
@@ -0,0 +1,13 @@
+-- GET /orders: ключ сортировки по дате (заказы без даты — как самые старые) и uid
+CREATE INDEX IF NOT EXISTS orders_created_idx
+  ON orders ((COALESCE(date_created, '-infinity'::timestamptz)), order_uid);
+
+-- фильтр по покупателю с той же сортировкой и поиск по трек-номеру;
+-- у delivery_service, entry, locale, currency, provider и bank значений мало,
+-- отдельные индексы по ним планировщик всё равно не выберет
+CREATE INDEX IF NOT EXISTS orders_customer_created_idx
+  ON orders (customer_id, (COALESCE(date_created, '-infinity'::timestamptz)), order_uid);
+CREATE INDEX IF NOT EXISTS orders_track_number_idx ON orders (track_number);
+
+-- товары страницы собираются подзапросом по order_uid
+CREATE INDEX IF NOT EXISTS items_order_uid_idx ON items (order_uid);
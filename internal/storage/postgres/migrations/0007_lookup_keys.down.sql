@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS items_rid_idx;
+DROP INDEX IF EXISTS payments_transaction_idx;
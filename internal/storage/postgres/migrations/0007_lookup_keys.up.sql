@@ -0,0 +1,4 @@
+-- поиск заказа по транзакции и rid товара; трек-номер и покупатель
+-- уже покрыты индексами из 0006_orders_listing
+CREATE INDEX IF NOT EXISTS payments_transaction_idx ON payments (transaction);
+CREATE INDEX IF NOT EXISTS items_rid_idx ON items (rid);
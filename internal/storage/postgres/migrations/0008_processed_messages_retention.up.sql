@@ -0,0 +1,2 @@
+-- срок хранения отметок processed_messages: очистка по processed_at
+CREATE INDEX IF NOT EXISTS processed_messages_processed_at_idx ON processed_messages (processed_at);
@@ -0,0 +1,59 @@
+package postgres
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// PruneProcessedMessages удаляет из processed_messages отметки старше before
+// порциями по limit строк, чтобы не держать долгих блокировок. Возвращает
+// число удалённых строк.
+func (r *Repository) PruneProcessedMessages(ctx context.Context, before time.Time, limit int) (int64, error) {
+	var total int64
+	for {
+		res, err := r.db.ExecContext(ctx, `
+			DELETE FROM processed_messages WHERE message_id IN (
+			    SELECT message_id FROM processed_messages
+			    WHERE processed_at < $1
+			    ORDER BY processed_at
+			    LIMIT $2
+			)
+		`, before, limit)
+		if err != nil {
+			return total, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if n < int64(limit) {
+			return total, nil
+		}
+	}
+}
+
+// RunProcessedCleanup каждые every удаляет отметки о сообщениях старше
+// retention, пока не отменён ctx. Повтор сообщения старше retention уже не
+// распознаётся как дубликат, поэтому срок должен быть больше, чем сообщение
+// может пролежать в Kafka до повторной доставки.
+func (r *Repository) RunProcessedCleanup(ctx context.Context, retention, every time.Duration, limit int) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			n, err := r.PruneProcessedMessages(ctx, time.Now().Add(-retention), limit)
+			if err != nil {
+				log.Printf("processed messages cleanup error: %v", err)
+				continue
+			}
+			if n > 0 {
+				log.Printf("processed messages cleanup: %d removed", n)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
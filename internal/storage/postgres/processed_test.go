@@ -0,0 +1,28 @@
+package postgres
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestPruneProcessedMessages_Batches(t *testing.T) {
+	r, mock, done := mustRepo(t)
+	defer done()
+
+	before := time.Now().Add(-24 * time.Hour)
+	query := regexp.QuoteMeta(`DELETE FROM processed_messages WHERE message_id IN (`)
+	mock.ExpectExec(query).WithArgs(before, 2).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(query).WithArgs(before, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	n, err := r.PruneProcessedMessages(context.Background(), before, 2)
+	if err != nil || n != 3 {
+		t.Fatalf("PruneProcessedMessages = %d, %v; want 3", n, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
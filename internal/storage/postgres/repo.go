@@ -1,11 +1,16 @@
 package postgres
 
-import(
+import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
-	_ "github.com/lib/pq"
+	"time"
+
+	"github.com/CodenSell/WB_test_level0/internal/storage"
 	"github.com/CodenSell/WB_test_level0/internal/structs"
+	"github.com/lib/pq"
 )
 
 type Repository struct {
@@ -28,75 +33,110 @@ func NewRepository(user, password, dbname, host string, port int) (*Repository,
 	return &Repository{db: db}, nil
 }
 
+// selectOrders — заказ целиком одной строкой: доставка и оплата через JOIN,
+// товары — JSON-массивом с ключами как в structs.Items (цены — в минимальных
+// единицах, как в колонках: валюту знает только заказ).
+const selectOrders = `
+	SELECT o.order_uid, o.track_number, o.entry, o.locale, o.internal_signature,
+	       o.customer_id, o.delivery_service, o.shardkey, o.sm_id, o.date_created, o.oof_shard,
+	       d.name, d.phone, d.zip, d.city, d.address, d.region, d.email,
+	       p.transaction, p.request_id, p.currency, p.provider, p.amount, p.payment_dt, p.bank,
+	       p.delivery_cost, p.goods_total, p.custom_fee,
+	       COALESCE((
+	           SELECT json_agg(json_build_object(
+	                      'chrt_id', i.chrt_id, 'track_number', i.track_number, 'price', i.price,
+	                      'rid', i.rid, 'name', i.name, 'sale', i.sale, 'size', i.size,
+	                      'total_price', i.total_price, 'nm_id', i.nm_id, 'brand', i.brand,
+	                      'status', i.status) ORDER BY i.id)
+	           FROM items i WHERE i.order_uid = o.order_uid
+	       ), '[]')
+	FROM orders o
+	JOIN deliveries d ON d.order_uid = o.order_uid
+	JOIN payments p ON p.order_uid = o.order_uid
+`
+
+// GetOrder читает заказ одним запросом; sql.ErrNoRows — заказа нет.
 func (r *Repository) GetOrder(ctx context.Context, orderUID string) (*structs.Order, error) {
-	var o structs.Order
+	return scanOrder(r.db.QueryRowContext(ctx, selectOrders+`WHERE o.order_uid = $1`, orderUID))
+}
 
-	err := r.db.QueryRow(`
-		SELECT order_uid, track_number, entry, locale, internal_signature,
-		       customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard
-		FROM orders WHERE order_uid=$1
-	`, orderUID).Scan(
-		&o.OrderUID, &o.TrackNumber, &o.Entry, &o.Localization, &o.InternalSignature,
-		&o.CustomerID, &o.DeliveryService, &o.ShardKey, &o.StorageID,
-		&o.DateCreated, &o.OofShard,
-	)
-	if err != nil {
-		return nil, err
+// GetOrders читает пачку заказов одним запросом. Порядок — как в uids;
+// заказы, которых нет в БД, пропускаются.
+func (r *Repository) GetOrders(ctx context.Context, uids []string) ([]structs.Order, error) {
+	if len(uids) == 0 {
+		return nil, nil
 	}
-
-	err = r.db.QueryRow(`
-		SELECT name, phone, zip, city, address, region, email
-		FROM deliveries WHERE order_uid=$1
-	`, orderUID).Scan(
-		&o.Delivery.Name, &o.Delivery.Phone, &o.Delivery.ZIP, &o.Delivery.City,
-		&o.Delivery.Address, &o.Delivery.Region, &o.Delivery.Email,
-	)
+	rows, err := r.db.QueryContext(ctx, selectOrders+`WHERE o.order_uid = ANY($1)`, pq.Array(uids))
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	err = r.db.QueryRow(`
-		SELECT transaction, request_id, currency, provider, amount, payment_dt, bank,
-		       delivery_cost, goods_total, custom_fee
-		FROM payments WHERE order_uid=$1
-	`, orderUID).Scan(
-		&o.Payment.Transaction, &o.Payment.RequestID, &o.Payment.Currency,
-		&o.Payment.Provider, &o.Payment.Amount, &o.Payment.PaymentDT,
-		&o.Payment.Bank, &o.Payment.DeliveryCost, &o.Payment.GoodsTotal,
-		&o.Payment.CustomFee,
-	)
-	if err != nil {
-		return nil, err
+	byUID := make(map[string]*structs.Order, len(uids))
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		byUID[o.OrderUID] = o
 	}
- 
-	rows, err := r.db.Query(`
-		SELECT chrt_id, track_number, price, rid, name, sale, size,
-		       total_price, nm_id, brand, status
-		FROM items WHERE order_uid=$1
-	`, orderUID)
-	if err != nil {
+	if err := rows.Err(); err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var it structs.Items
-		if err := rows.Scan(
-			&it.ChartID, &it.TrackNumber, &it.Price, &it.Rid,
-			&it.Name, &it.Sale, &it.Size, &it.TotalPrice,
-			&it.NomenclatureID, &it.Brand, &it.Status,
-		); err != nil {
-			return nil, err
+	out := make([]structs.Order, 0, len(byUID))
+	for _, uid := range uids {
+		if o, ok := byUID[uid]; ok {
+			out = append(out, *o)
+			delete(byUID, uid)
 		}
-		o.Items = append(o.Items, it)
 	}
+	return out, nil
+}
 
+func scanOrder(row interface{ Scan(dest ...any) error }) (*structs.Order, error) {
+	var o structs.Order
+	var created, paid sql.NullTime
+	var items []byte
+	if err := row.Scan(
+		&o.OrderUID, &o.TrackNumber, &o.Entry, &o.Localization, &o.InternalSignature,
+		&o.CustomerID, &o.DeliveryService, &o.ShardKey, &o.StorageID, &created, &o.OofShard,
+		&o.Delivery.Name, &o.Delivery.Phone, &o.Delivery.ZIP, &o.Delivery.City,
+		&o.Delivery.Address, &o.Delivery.Region, &o.Delivery.Email,
+		&o.Payment.Transaction, &o.Payment.RequestID, &o.Payment.Currency, &o.Payment.Provider,
+		&o.Payment.Amount, &paid, &o.Payment.Bank,
+		&o.Payment.DeliveryCost, &o.Payment.GoodsTotal, &o.Payment.CustomFee,
+		&items,
+	); err != nil {
+		return nil, err
+	}
+	o.DateCreated, o.Payment.PaymentDT = created.Time, paid.Time
+	if err := json.Unmarshal(items, &o.Items); err != nil {
+		return nil, fmt.Errorf("order %s items: %w", o.OrderUID, err)
+	}
+	if len(o.Items) == 0 {
+		o.Items = nil
+	}
 	return &o, nil
 }
-func (r *Repository) UpsertOrder(ctx context.Context, o *structs.Order) error {
+
+// nullTime — незаданное время (нулевое значение) пишется в БД как NULL.
+func nullTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// UpsertOrder сохраняет заказ целиком; created — заказа с таким uid раньше не было.
+// Если meta.MessageID уже встречался, ничего не пишет и возвращает storage.ErrDuplicate,
+// если в БД версия заказа новее meta.Version — storage.ErrStale. Запись без версии
+// только создаёт заказ: существующий она не перезаписывает и тоже получает ErrStale.
+// MessageID устаревшей записи сохраняется, как и в UpsertOrders.
+func (r *Repository) UpsertOrder(ctx context.Context, o *structs.Order, meta storage.Meta) (created bool, err error) {
 	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{})
 	if err != nil {
-		return err
+		return false, err
 	}
 	defer func() {
 		if err != nil {
@@ -104,10 +144,30 @@ func (r *Repository) UpsertOrder(ctx context.Context, o *structs.Order) error {
 		}
 	}()
 
-	_, err = tx.ExecContext(ctx, `
+	if meta.MessageID != "" {
+		var res sql.Result
+		res, err = tx.ExecContext(ctx, `
+			INSERT INTO processed_messages (message_id, order_uid) VALUES ($1,$2)
+			ON CONFLICT (message_id) DO NOTHING
+		`, meta.MessageID, o.OrderUID)
+		if err != nil {
+			return false, err
+		}
+		var n int64
+		if n, err = res.RowsAffected(); err != nil {
+			return false, err
+		}
+		if n == 0 {
+			err = storage.ErrDuplicate
+			return false, err
+		}
+	}
+
+	var revision int
+	err = tx.QueryRowContext(ctx, `
 		INSERT INTO orders (order_uid, track_number, entry, locale, internal_signature,
-		                    customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
+		                    customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard, version)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
 		ON CONFLICT (order_uid) DO UPDATE SET
 		    track_number=EXCLUDED.track_number,
 		    entry=EXCLUDED.entry,
@@ -118,11 +178,25 @@ func (r *Repository) UpsertOrder(ctx context.Context, o *structs.Order) error {
 		    shardkey=EXCLUDED.shardkey,
 		    sm_id=EXCLUDED.sm_id,
 		    date_created=EXCLUDED.date_created,
-		    oof_shard=EXCLUDED.oof_shard
+		    oof_shard=EXCLUDED.oof_shard,
+		    version=GREATEST(orders.version, EXCLUDED.version),
+		    revision=orders.revision + 1,
+		    updated_at=now()
+		WHERE EXCLUDED.version <> 0 AND orders.version <= EXCLUDED.version
+		RETURNING (xmax = 0), revision
 	`, o.OrderUID, o.TrackNumber, o.Entry, o.Localization, o.InternalSignature,
-		o.CustomerID, o.DeliveryService, o.ShardKey, o.StorageID, o.DateCreated, o.OofShard)
+		o.CustomerID, o.DeliveryService, o.ShardKey, o.StorageID, nullTime(o.DateCreated), o.OofShard,
+		meta.Version).Scan(&created, &revision)
+	if errors.Is(err, sql.ErrNoRows) {
+		// WHERE в ON CONFLICT не пропустил обновление: в БД версия новее.
+		// Сообщение всё равно отмечается обработанным, как и в UpsertOrders
+		if err = tx.Commit(); err != nil {
+			return false, err
+		}
+		return false, storage.ErrStale
+	}
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	_, err = tx.ExecContext(ctx, `
@@ -139,7 +213,7 @@ func (r *Repository) UpsertOrder(ctx context.Context, o *structs.Order) error {
 	`, o.OrderUID, o.Delivery.Name, o.Delivery.Phone, o.Delivery.ZIP, o.Delivery.City,
 		o.Delivery.Address, o.Delivery.Region, o.Delivery.Email)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	_, err = tx.ExecContext(ctx, `
@@ -158,48 +232,58 @@ func (r *Repository) UpsertOrder(ctx context.Context, o *structs.Order) error {
 		    goods_total=EXCLUDED.goods_total,
 		    custom_fee=EXCLUDED.custom_fee
 	`, o.OrderUID, o.Payment.Transaction, o.Payment.RequestID, o.Payment.Currency, o.Payment.Provider,
-		o.Payment.Amount, o.Payment.PaymentDT, o.Payment.Bank, o.Payment.DeliveryCost,
+		o.Payment.Amount, nullTime(o.Payment.PaymentDT), o.Payment.Bank, o.Payment.DeliveryCost,
 		o.Payment.GoodsTotal, o.Payment.CustomFee)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	if _, err = tx.ExecContext(ctx, `DELETE FROM items WHERE order_uid=$1`, o.OrderUID); err != nil {
-		return err
+		return false, err
 	}
-	stmt, err := tx.PrepareContext(ctx, `
-		INSERT INTO items (order_uid, chrt_id, track_number, price, rid, name, sale, size,
-		                   total_price, nm_id, brand, status)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
-	`)
-	if err != nil {
-		return err
+	if err = insertItems(ctx, tx, appendItemRows(nil, o)); err != nil {
+		return false, err
 	}
-	defer stmt.Close()
 
-	for _, it := range o.Items {
-		if _, err = stmt.ExecContext(ctx, o.OrderUID, it.ChartID, it.TrackNumber, it.Price, it.Rid,
-			it.Name, it.Sale, it.Size, it.TotalPrice, it.NomenclatureID, it.Brand, it.Status); err != nil {
-			return err
-		}
+	if err = insertRevisions(ctx, tx, []revisionRow{{order: o, revision: revision, meta: meta}}); err != nil {
+		return false, err
 	}
 
 	err = tx.Commit()
-	return err
+	return created, err
 }
-func (r *Repository)ListOrderUIDs(ctx context.Context)([]string, error){
+
+func (r *Repository) ListOrderUIDs(ctx context.Context) ([]string, error) {
 	rows, err := r.db.QueryContext(ctx, `SELECT order_uid FROM orders`)
-	if err != nil{
+	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	var uids []string
-	for rows.Next(){
+	for rows.Next() {
+		var uid string
+		if err := rows.Scan(&uid); err != nil {
+			return nil, err
+		}
+		uids = append(uids, uid)
+	}
+	return uids, rows.Err()
+}
+
+// ListOrderUIDsChangedSince — uid заказов, записанных не раньше since.
+func (r *Repository) ListOrderUIDsChangedSince(ctx context.Context, since time.Time) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT order_uid FROM orders WHERE updated_at >= $1`, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var uids []string
+	for rows.Next() {
 		var uid string
-		if err := rows.Scan(&uid); err != nil{
+		if err := rows.Scan(&uid); err != nil {
 			return nil, err
 		}
 		uids = append(uids, uid)
 	}
 	return uids, rows.Err()
-}
\ No newline at end of file
+}
@@ -2,10 +2,14 @@ package postgres
 
 import (
 	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"regexp"
 	"testing"
 	"time"
 
+	"github.com/CodenSell/WB_test_level0/internal/storage"
 	"github.com/CodenSell/WB_test_level0/internal/structs"
 	sqlmock "github.com/DATA-DOG/go-sqlmock"
 )
@@ -21,58 +25,98 @@ func mustRepo(t *testing.T) (*Repository, sqlmock.Sqlmock, func()) {
 	return r, mock, cleanup
 }
 
+var orderColumns = []string{
+	"order_uid", "track_number", "entry", "locale", "internal_signature",
+	"customer_id", "delivery_service", "shardkey", "sm_id", "date_created", "oof_shard",
+	"name", "phone", "zip", "city", "address", "region", "email",
+	"transaction", "request_id", "currency", "provider", "amount", "payment_dt", "bank",
+	"delivery_cost", "goods_total", "custom_fee",
+	"items",
+}
+
+func orderRow(uid, items string) []driver.Value {
+	return []driver.Value{uid, "WBTR", "WBIL", "en", "",
+		"cust", "meest", "9", 99, time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC), "1",
+		"Name", "+1", "000", "City", "Addr", "Reg", "a@b.c",
+		"tx", "", "USD", "wbpay", 10000, nil, "alpha", 1000, 9000, 0,
+		[]byte(items)}
+}
+
 func TestGetOrder_OK(t *testing.T) {
 	r, mock, done := mustRepo(t)
 	defer done()
 
 	orderUID := "uid-1"
-
-	mock.ExpectQuery(regexp.QuoteMeta(`
-		SELECT order_uid, track_number, entry, locale, internal_signature,
-		       customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard
-		FROM orders WHERE order_uid=$1`)).
+	mock.ExpectQuery(regexp.QuoteMeta(selectOrders + `WHERE o.order_uid = $1`)).
 		WithArgs(orderUID).
-		WillReturnRows(sqlmock.NewRows([]string{
-			"order_uid", "track_number", "entry", "locale", "internal_signature",
-			"customer_id", "delivery_service", "shardkey", "sm_id", "date_created", "oof_shard",
-		}).AddRow(orderUID, "WBTR", "WBIL", "en", "",
-			"cust", "meest", "9", 99, time.Now().UTC().Format(time.RFC3339), "1"))
-
-	mock.ExpectQuery(regexp.QuoteMeta(`
-		SELECT name, phone, zip, city, address, region, email
-		FROM deliveries WHERE order_uid=$1`)).
-		WithArgs(orderUID).
-		WillReturnRows(sqlmock.NewRows([]string{
-			"name", "phone", "zip", "city", "address", "region", "email",
-		}).AddRow("Name", "+1", "000", "City", "Addr", "Reg", "a@b.c"))
-
-	mock.ExpectQuery(regexp.QuoteMeta(`
-		SELECT transaction, request_id, currency, provider, amount, payment_dt, bank,
-		       delivery_cost, goods_total, custom_fee
-		FROM payments WHERE order_uid=$1`)).
-		WithArgs(orderUID).
-		WillReturnRows(sqlmock.NewRows([]string{
-			"transaction", "request_id", "currency", "provider", "amount", "payment_dt", "bank",
-			"delivery_cost", "goods_total", "custom_fee",
-		}).AddRow("tx", "", "USD", "wbpay", 100, int64(1637907727), "alpha", 10, 90, 0))
-
-	mock.ExpectQuery(regexp.QuoteMeta(`
-		SELECT chrt_id, track_number, price, rid, name, sale, size,
-		       total_price, nm_id, brand, status
-		FROM items WHERE order_uid=$1`)).
-		WithArgs(orderUID).
-		WillReturnRows(sqlmock.NewRows([]string{
-			"chrt_id", "track_number", "price", "rid", "name", "sale", "size",
-			"total_price", "nm_id", "brand", "status",
-		}).AddRow(1, "WBTR", 10, "rid", "Mask", 0, "0", 10, 111, "Brand", 202))
+		WillReturnRows(sqlmock.NewRows(orderColumns).AddRow(orderRow(orderUID,
+			`[{"chrt_id":1,"track_number":"WBTR","price":453,"rid":"rid","name":"Mask","sale":0,"size":"0","total_price":400,"nm_id":111,"brand":"Brand","status":202}]`)...))
 
 	o, err := r.GetOrder(context.Background(), orderUID)
 	if err != nil {
 		t.Fatalf("GetOrder err: %v", err)
 	}
-	if o.OrderUID != orderUID || len(o.Items) != 1 || o.Payment.Amount != 100 {
+	if o.OrderUID != orderUID || len(o.Items) != 1 || o.Payment.Amount != 10000 || o.Delivery.City != "City" {
 		t.Fatalf("bad aggregate: %+v", o)
 	}
+	if o.DateCreated.Year() != 2021 || !o.Payment.PaymentDT.IsZero() {
+		t.Fatalf("bad dates: %v, %v", o.DateCreated, o.Payment.PaymentDT)
+	}
+	if it := o.Items[0]; it.ChartID != 1 || it.Price != 453 || it.TotalPrice != 400 || it.NomenclatureID != 111 || it.Status != 202 {
+		t.Fatalf("bad item: %+v", it)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestGetOrder_NotFound(t *testing.T) {
+	r, mock, done := mustRepo(t)
+	defer done()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`WHERE o.order_uid = $1`)).
+		WithArgs("missing").
+		WillReturnRows(sqlmock.NewRows(orderColumns))
+
+	if _, err := r.GetOrder(context.Background(), "missing"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected sql.ErrNoRows, got %v", err)
+	}
+}
+
+func TestGetOrder_RespectsContext(t *testing.T) {
+	r, mock, done := mustRepo(t)
+	defer done()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`WHERE o.order_uid = $1`)).
+		WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows(orderColumns).AddRow(orderRow("uid-1", `[]`)...))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := r.GetOrder(ctx, "uid-1"); err == nil {
+		t.Fatal("expected an error from a cancelled context")
+	}
+}
+
+func TestGetOrders_OK(t *testing.T) {
+	r, mock, done := mustRepo(t)
+	defer done()
+
+	mock.ExpectQuery(regexp.QuoteMeta(selectOrders + `WHERE o.order_uid = ANY($1)`)).
+		WillReturnRows(sqlmock.NewRows(orderColumns).
+			AddRow(orderRow("b", `[]`)...).
+			AddRow(orderRow("a", `[{"chrt_id":1},{"chrt_id":2}]`)...))
+
+	orders, err := r.GetOrders(context.Background(), []string{"a", "missing", "b"})
+	if err != nil {
+		t.Fatalf("GetOrders err: %v", err)
+	}
+	if len(orders) != 2 || orders[0].OrderUID != "a" || orders[1].OrderUID != "b" {
+		t.Fatalf("bad orders: %+v", orders)
+	}
+	if len(orders[0].Items) != 2 || orders[1].Items != nil {
+		t.Fatalf("bad items: %+v / %+v", orders[0].Items, orders[1].Items)
+	}
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Fatalf("unmet expectations: %v", err)
 	}
@@ -91,26 +135,27 @@ func TestUpsertOrder_OK(t *testing.T) {
 		DeliveryService: "meest",
 		ShardKey:        "9",
 		StorageID:       99,
-		DateCreated:     time.Now().UTC().Format(time.RFC3339),
+		DateCreated:     time.Now().UTC().Truncate(time.Second),
 		OofShard:        "1",
 		Delivery: structs.Delivery{
 			Name: "N", Phone: "+1", ZIP: "000", City: "C", Address: "A", Region: "R", Email: "a@b.c",
 		},
 		Payment: structs.Payment{
 			Transaction: "tx", RequestID: "", Currency: "USD", Provider: "wbpay",
-			Amount: 100, PaymentDT: 1637907727, Bank: "alpha", DeliveryCost: 10, GoodsTotal: 90, CustomFee: 0,
+			Amount: 10000, PaymentDT: time.Unix(1637907727, 0).UTC(), Bank: "alpha", DeliveryCost: 1000, GoodsTotal: 9000, CustomFee: 0,
 		},
 		Items: []structs.Items{
-			{ChartID: 1, TrackNumber: "WBTR", Price: 10, Rid: "rid", Name: "Mask", Sale: 0, Size: "0", TotalPrice: 10, NomenclatureID: 111, Brand: "Brand", Status: 202},
+			{ChartID: 1, TrackNumber: "WBTR", Price: 1000, Rid: "rid", Name: "Mask", Sale: 0, Size: "0", TotalPrice: 1000, NomenclatureID: 111, Brand: "Brand", Status: 202},
+			{ChartID: 2, TrackNumber: "WBTR", Price: 500, Rid: "rid2", Name: "Brush", Sale: 10, Size: "1", TotalPrice: 450, NomenclatureID: 222, Brand: "Brand", Status: 202},
 		},
 	}
 
 	mock.ExpectBegin()
 
-	mock.ExpectExec(regexp.QuoteMeta(`
+	mock.ExpectQuery(regexp.QuoteMeta(`
 		INSERT INTO orders (order_uid, track_number, entry, locale, internal_signature,
-		                    customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
+		                    customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard, version)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
 		ON CONFLICT (order_uid) DO UPDATE SET
 		    track_number=EXCLUDED.track_number,
 		    entry=EXCLUDED.entry,
@@ -121,11 +166,16 @@ func TestUpsertOrder_OK(t *testing.T) {
 		    shardkey=EXCLUDED.shardkey,
 		    sm_id=EXCLUDED.sm_id,
 		    date_created=EXCLUDED.date_created,
-		    oof_shard=EXCLUDED.oof_shard`,
+		    oof_shard=EXCLUDED.oof_shard,
+		    version=GREATEST(orders.version, EXCLUDED.version),
+		    revision=orders.revision + 1,
+		    updated_at=now()
+		WHERE EXCLUDED.version <> 0 AND orders.version <= EXCLUDED.version
+		RETURNING (xmax = 0), revision`,
 	)).WithArgs(
 		o.OrderUID, o.TrackNumber, o.Entry, o.Localization, o.InternalSignature,
-		o.CustomerID, o.DeliveryService, o.ShardKey, o.StorageID, o.DateCreated, o.OofShard,
-	).WillReturnResult(sqlmock.NewResult(0, 1))
+		o.CustomerID, o.DeliveryService, o.ShardKey, o.StorageID, o.DateCreated, o.OofShard, int64(0),
+	).WillReturnRows(sqlmock.NewRows([]string{"inserted", "revision"}).AddRow(true, 1))
 
 	mock.ExpectExec(regexp.QuoteMeta(`
 		INSERT INTO deliveries (order_uid, name, phone, zip, city, address, region, email)
@@ -168,21 +218,80 @@ func TestUpsertOrder_OK(t *testing.T) {
 		WithArgs(o.OrderUID).
 		WillReturnResult(sqlmock.NewResult(0, 1))
 
-	mock.ExpectPrepare(regexp.QuoteMeta(`
+	// все товары — одним многострочным INSERT
+	var itemArgs []driver.Value
+	for _, it := range o.Items {
+		itemArgs = append(itemArgs, o.OrderUID, it.ChartID, it.TrackNumber, it.Price, it.Rid,
+			it.Name, it.Sale, it.Size, it.TotalPrice, it.NomenclatureID, it.Brand, it.Status)
+	}
+	mock.ExpectExec(regexp.QuoteMeta(`
 		INSERT INTO items (order_uid, chrt_id, track_number, price, rid, name, sale, size,
 		                   total_price, nm_id, brand, status)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
-	`)).
-		ExpectExec().
-		WithArgs(o.OrderUID, o.Items[0].ChartID, o.Items[0].TrackNumber, o.Items[0].Price, o.Items[0].Rid,
-			o.Items[0].Name, o.Items[0].Sale, o.Items[0].Size, o.Items[0].TotalPrice, o.Items[0].NomenclatureID, o.Items[0].Brand, o.Items[0].Status).
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12),($13,$14,$15,$16,$17,$18,$19,$20,$21,$22,$23,$24)`)).
+		WithArgs(itemArgs...).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	mock.ExpectExec(regexp.QuoteMeta(`
+		INSERT INTO order_revisions (order_uid, revision, snapshot, source, source_ref,
+		                             message_id, version, received_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8)`)).
+		WithArgs(o.OrderUID, 1, sqlmock.AnyArg(), "http", "10.0.0.1:5555", "", int64(0), sqlmock.AnyArg()).
 		WillReturnResult(sqlmock.NewResult(0, 1))
 
 	mock.ExpectCommit()
 
-	if err := r.UpsertOrder(context.Background(), o); err != nil {
+	created, err := r.UpsertOrder(context.Background(), o, storage.Meta{Source: "http", SourceRef: "10.0.0.1:5555"})
+	if err != nil {
 		t.Fatalf("UpsertOrder err: %v", err)
 	}
+	if !created {
+		t.Fatalf("expected created for a new order")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestUpsertOrder_Duplicate(t *testing.T) {
+	r, mock, done := mustRepo(t)
+	defer done()
+
+	o := &structs.Order{OrderUID: "u1"}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO processed_messages (message_id, order_uid) VALUES ($1,$2)`)).
+		WithArgs("orders/0/42", "u1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	_, err := r.UpsertOrder(context.Background(), o, storage.Meta{MessageID: "orders/0/42"})
+	if !errors.Is(err, storage.ErrDuplicate) {
+		t.Fatalf("expected ErrDuplicate, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestUpsertOrder_Stale(t *testing.T) {
+	r, mock, done := mustRepo(t)
+	defer done()
+
+	o := &structs.Order{OrderUID: "u1"}
+
+	// отметка о сообщении остаётся, как в пакетной записи
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO processed_messages (message_id, order_uid) VALUES ($1,$2)`)).
+		WithArgs("orders/0/42", "u1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(`WHERE EXCLUDED.version <> 0 AND orders.version <= EXCLUDED.version`)).
+		WillReturnRows(sqlmock.NewRows([]string{"inserted", "revision"}))
+	mock.ExpectCommit()
+
+	_, err := r.UpsertOrder(context.Background(), o, storage.Meta{MessageID: "orders/0/42", Version: 100})
+	if !errors.Is(err, storage.ErrStale) {
+		t.Fatalf("expected ErrStale, got %v", err)
+	}
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Fatalf("unmet expectations: %v", err)
 	}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/CodenSell/WB_test_level0/internal/structs"
+)
+
+// ErrBadCursor — курсор страницы не разобран или выдан для другой сортировки.
+var ErrBadCursor = errors.New("bad cursor")
+
+// OrderFilter — условия выборки заказов; пустое поле — без условия.
+type OrderFilter struct {
+	CustomerID      string
+	TrackNumber     string
+	DeliveryService string
+	Entry           string
+	Locale          string
+	Currency        string
+	Provider        string
+	Bank            string
+	// CreatedFrom и CreatedTo — полуинтервал [from, to) по date_created.
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+}
+
+// OrderSort — ключ сортировки списка; порядок внутри одного значения ключа — по order_uid.
+type OrderSort struct {
+	Field string // date_created или order_uid
+	Desc  bool
+}
+
+// DefaultOrderSort — сначала новые заказы.
+var DefaultOrderSort = OrderSort{Field: "date_created", Desc: true}
+
+// ParseOrderSort разбирает "date_created", "-date_created", "order_uid", "-order_uid".
+func ParseOrderSort(s string) (OrderSort, error) {
+	if s == "" {
+		return DefaultOrderSort, nil
+	}
+	field, desc := strings.CutPrefix(s, "-")
+	if field != "date_created" && field != "order_uid" {
+		return OrderSort{}, fmt.Errorf("unknown sort %q", s)
+	}
+	return OrderSort{Field: field, Desc: desc}, nil
+}
+
+func (s OrderSort) String() string {
+	if s.Desc {
+		return "-" + s.Field
+	}
+	return s.Field
+}
+
+// Cursor — последний заказ предыдущей страницы: следующая начинается строго после него.
+type Cursor struct {
+	Sort        string    `json:"s"`
+	DateCreated time.Time `json:"c,omitzero"`
+	OrderUID    string    `json:"u"`
+}
+
+// CursorAfter — курсор, указывающий на заказ o при сортировке sort.
+func CursorAfter(sort OrderSort, o *structs.Order) Cursor {
+	return Cursor{Sort: sort.String(), DateCreated: o.DateCreated, OrderUID: o.OrderUID}
+}
+
+// Encode — непрозрачная строка для параметра cursor.
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor разбирает строку из Encode и проверяет, что курсор выдан для sort.
+func DecodeCursor(s string, sort OrderSort) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+	var c Cursor
+	if err := json.Unmarshal(b, &c); err != nil || c.OrderUID == "" || c.Sort != sort.String() {
+		return nil, ErrBadCursor
+	}
+	return &c, nil
+}
+
+// OrderQuery — одна страница списка заказов.
+type OrderQuery struct {
+	Filter OrderFilter
+	Sort   OrderSort
+	// After — курсор предыдущей страницы; nil — первая страница.
+	After *Cursor
+	Limit int
+}
+
+type OrderPage struct {
+	Orders []structs.Order `json:"orders"`
+	// Next — курсор следующей страницы; пустой — это последняя страница.
+	Next string `json:"next_cursor,omitempty"`
+}
@@ -1,12 +1,75 @@
 package storage
 
-import(
+import (
 	"context"
+	"errors"
+	"time"
+
 	"github.com/CodenSell/WB_test_level0/internal/structs"
 )
 
-type OrderRepo interface{
-	GetOrder(ctx context.Context, uid string)(*structs.Order, error)
-	UpsertOrder(ctx context.Context, o *structs.Order) error
-	ListOrderUIDs(ctx context.Context)([]string, error)
-}
\ No newline at end of file
+var (
+	// ErrDuplicate — сообщение с таким MessageID уже было применено.
+	ErrDuplicate = errors.New("duplicate message")
+	// ErrStale — в БД уже лежит более новая версия заказа.
+	ErrStale = errors.New("stale order version")
+)
+
+// MaxVersionSkew — насколько версия записи может опережать время сервера.
+// Версия из будущего закрепила бы заказ: все следующие обновления, версии
+// которых — время события, отклонялись бы как устаревшие.
+const MaxVersionSkew = time.Minute
+
+// Meta описывает, откуда пришла запись заказа.
+type Meta struct {
+	// MessageID — идентификатор сообщения для дедупликации; пустой — без дедупликации.
+	MessageID string
+	// Version — монотонная версия записи (например, время события в микросекундах).
+	// Запись с меньшей версией, чем в БД, отклоняется; 0 — версия неизвестна,
+	// такая запись только создаёт заказ, а существующий не трогает.
+	Version int64
+	// Source — тип источника: kafka, http, file, chan.
+	Source string
+	// SourceRef — откуда именно: topic/partition/offset, адрес HTTP-клиента, file:line.
+	SourceRef string
+	// ReceivedAt — когда запись получена сервисом; нулевое — момент записи в БД.
+	ReceivedAt time.Time
+}
+
+// RevisionInfo — сведения о принятой ревизии заказа без самого снимка.
+type RevisionInfo struct {
+	OrderUID   string    `json:"order_uid"`
+	Revision   int       `json:"revision"`
+	Source     string    `json:"source"`
+	SourceRef  string    `json:"source_ref,omitempty"`
+	MessageID  string    `json:"message_id,omitempty"`
+	Version    int64     `json:"version"`
+	ReceivedAt time.Time `json:"received_at"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// Revision — снимок заказа в одной из принятых ревизий.
+type Revision struct {
+	RevisionInfo
+	Order structs.Order `json:"order"`
+}
+
+type OrderWrite struct {
+	Order *structs.Order
+	Meta  Meta
+}
+
+type OrderRepo interface {
+	GetOrder(ctx context.Context, uid string) (*structs.Order, error)
+	UpsertOrder(ctx context.Context, o *structs.Order, meta Meta) (created bool, err error)
+	// UpsertOrders пишет пачку одной транзакцией и возвращает по результату на запись: nil, ErrDuplicate или ErrStale.
+	UpsertOrders(ctx context.Context, writes []OrderWrite) ([]error, error)
+	ListOrderUIDs(ctx context.Context) ([]string, error)
+	// ListOrderUIDsChangedSince — uid заказов, записанных не раньше since.
+	ListOrderUIDsChangedSince(ctx context.Context, since time.Time) ([]string, error)
+	// GetOrders читает пачку заказов целиком; отсутствующие в БД пропускаются.
+	GetOrders(ctx context.Context, uids []string) ([]structs.Order, error)
+	// FindOrderUIDs — uid заказов со значением value ключа key, сначала новые,
+	// не больше MaxLookupOrders.
+	FindOrderUIDs(ctx context.Context, key LookupKey, value string) ([]string, error)
+}
@@ -0,0 +1,208 @@
+// Package storagetest — хранилище заказов в памяти для тестов пакетов,
+// которые работают с storage.OrderRepo.
+package storagetest
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/CodenSell/WB_test_level0/internal/storage"
+	"github.com/CodenSell/WB_test_level0/internal/structs"
+)
+
+// ErrDown — ошибка записи заказов из Fail, как при недоступной БД.
+var ErrDown = errors.New("db is down")
+
+// Repo реализует storage.OrderRepo поверх map. Как и Postgres, на отсутствующий
+// заказ GetOrder отвечает sql.ErrNoRows, а запись с уже применённым MessageID —
+// storage.ErrDuplicate. Поля настраиваются до начала работы с Repo.
+type Repo struct {
+	mu     sync.Mutex
+	orders map[string]structs.Order
+	// processed — применённые MessageID.
+	processed map[string]bool
+
+	gets, finds, batches int
+
+	// Fail — uid, запись которых падает с ErrDown; UpsertOrders тогда
+	// отклоняет всю пачку.
+	Fail map[string]bool
+	// Stale — uid, запись которых отклоняется с storage.ErrStale.
+	Stale map[string]bool
+	// Changed — ответ ListOrderUIDsChangedSince.
+	Changed []string
+	// Block, если задан, задерживает GetOrder до закрытия канала или отмены ctx.
+	Block chan struct{}
+	// Delay — задержка каждого GetOrders.
+	Delay time.Duration
+}
+
+var _ storage.OrderRepo = (*Repo)(nil)
+
+// NewRepo создаёт хранилище с пустыми заказами с перечисленными uid.
+func NewRepo(uids ...string) *Repo {
+	r := &Repo{
+		orders:    make(map[string]structs.Order),
+		processed: make(map[string]bool),
+		Fail:      make(map[string]bool),
+		Stale:     make(map[string]bool),
+	}
+	for _, uid := range uids {
+		r.orders[uid] = structs.Order{OrderUID: uid}
+	}
+	return r
+}
+
+// Put кладёт заказ в хранилище в обход кэша — как правка прямо в БД.
+func (r *Repo) Put(o structs.Order) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.orders[o.OrderUID] = o
+}
+
+// Order возвращает сохранённый заказ.
+func (r *Repo) Order(uid string) (structs.Order, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	o, ok := r.orders[uid]
+	return o, ok
+}
+
+// Len — число сохранённых заказов.
+func (r *Repo) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.orders)
+}
+
+// Gets — сколько заказов прочитано через GetOrder и GetOrders.
+func (r *Repo) Gets() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.gets
+}
+
+// Finds — сколько раз вызывался FindOrderUIDs.
+func (r *Repo) Finds() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.finds
+}
+
+// Batches — сколько пачек записал UpsertOrders.
+func (r *Repo) Batches() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.batches
+}
+
+func (r *Repo) GetOrder(ctx context.Context, uid string) (*structs.Order, error) {
+	if r.Block != nil {
+		select {
+		case <-r.Block:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.gets++
+	o, ok := r.orders[uid]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &o, nil
+}
+
+func (r *Repo) UpsertOrder(_ context.Context, o *structs.Order, meta storage.Meta) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Fail[o.OrderUID] {
+		return false, ErrDown
+	}
+	return r.upsert(o, meta)
+}
+
+func (r *Repo) UpsertOrders(_ context.Context, writes []storage.OrderWrite) ([]error, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, w := range writes {
+		if r.Fail[w.Order.OrderUID] {
+			return nil, ErrDown
+		}
+	}
+	results := make([]error, len(writes))
+	for i, w := range writes {
+		_, results[i] = r.upsert(w.Order, w.Meta)
+	}
+	r.batches++
+	return results, nil
+}
+
+func (r *Repo) upsert(o *structs.Order, meta storage.Meta) (bool, error) {
+	if meta.MessageID != "" && r.processed[meta.MessageID] {
+		return false, storage.ErrDuplicate
+	}
+	if r.Stale[o.OrderUID] {
+		return false, storage.ErrStale
+	}
+	if meta.MessageID != "" {
+		r.processed[meta.MessageID] = true
+	}
+	_, exists := r.orders[o.OrderUID]
+	r.orders[o.OrderUID] = *o
+	return !exists, nil
+}
+
+func (r *Repo) ListOrderUIDs(context.Context) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	uids := make([]string, 0, len(r.orders))
+	for uid := range r.orders {
+		uids = append(uids, uid)
+	}
+	return uids, nil
+}
+
+func (r *Repo) GetOrders(ctx context.Context, uids []string) ([]structs.Order, error) {
+	time.Sleep(r.Delay)
+	out := make([]structs.Order, 0, len(uids))
+	for _, uid := range uids {
+		if o, err := r.GetOrder(ctx, uid); err == nil {
+			out = append(out, *o)
+		}
+	}
+	return out, nil
+}
+
+func (r *Repo) ListOrderUIDsChangedSince(context.Context, time.Time) ([]string, error) {
+	return r.Changed, nil
+}
+
+func (r *Repo) FindOrderUIDs(_ context.Context, key storage.LookupKey, value string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.finds++
+	var found []structs.Order
+	for _, o := range r.orders {
+		if key.Has(&o, value) {
+			found = append(found, o)
+		}
+	}
+	slices.SortFunc(found, func(x, y structs.Order) int {
+		if c := y.DateCreated.Compare(x.DateCreated); c != 0 {
+			return c
+		}
+		return cmp.Compare(y.OrderUID, x.OrderUID)
+	})
+	uids := make([]string, 0, min(len(found), storage.MaxLookupOrders))
+	for _, o := range found[:min(len(found), storage.MaxLookupOrders)] {
+		uids = append(uids, o.OrderUID)
+	}
+	return uids, nil
+}
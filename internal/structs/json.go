@@ -0,0 +1,258 @@
+package structs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Money — сумма в минимальных единицах валюты заказа: центах, копейках, а у
+// валют без дробной части (JPY, KRW) — в самих основных единицах. Сколько
+// минимальных единиц в основной, задаёт валюта (см. CurrencyExponent), поэтому
+// в JSON суммы в основных единицах пишут кодеки Payment и Order, которые её
+// знают: 1817 — 1817.00 USD, 18.17 — 18.17 USD, 1.234 — 1.234 BHD.
+type Money int64
+
+// Format — сумма в основных единицах валюты с exp знаками после запятой:
+// целые без дробной части, остальные — ровно с exp знаками.
+func (m Money) Format(exp int) string {
+	sign, v := "", int64(m)
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	scale := pow10(exp)
+	if v%scale == 0 {
+		return sign + strconv.FormatInt(v/scale, 10)
+	}
+	return fmt.Sprintf("%s%d.%0*d", sign, v/scale, exp, v%scale)
+}
+
+// ParseMoney разбирает число в основных единицах валюты с exp знаками; значащих
+// знаков после запятой может быть не больше exp.
+func ParseMoney(s string, exp int) (Money, error) {
+	neg := strings.HasPrefix(s, "-")
+	units, frac, _ := strings.Cut(strings.TrimPrefix(s, "-"), ".")
+	if strings.TrimLeft(units+frac, "0123456789") != "" || units == "" {
+		return 0, fmt.Errorf("money: want a number with at most %d decimal places, got %s", exp, s)
+	}
+	frac = strings.TrimRight(frac, "0")
+	if len(frac) > exp {
+		return 0, fmt.Errorf("money: want a number with at most %d decimal places, got %s", exp, s)
+	}
+	v, err := strconv.ParseInt(units, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("money: %w", err)
+	}
+	v *= pow10(exp)
+	if frac != "" {
+		f, _ := strconv.ParseInt(frac+strings.Repeat("0", exp-len(frac)), 10, 64)
+		v += f
+	}
+	if neg {
+		v = -v
+	}
+	return Money(v), nil
+}
+
+func pow10(n int) int64 {
+	p := int64(1)
+	for range n {
+		p *= 10
+	}
+	return p
+}
+
+// Amount — сумма вместе с числом знаков её валюты для мест, где она живёт
+// отдельно от заказа (например, в сравнении ревизий); печатается и пишется в
+// JSON в основных единицах.
+type Amount struct {
+	Value Money
+	Exp   int
+}
+
+func (a Amount) String() string { return a.Value.Format(a.Exp) }
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// Exponent — число знаков после запятой у валюты платежа; у неизвестной — 2.
+func (p Payment) Exponent() int { return exponent(p.Currency) }
+
+// Format печатает сумму заказа в основных единицах валюты платежа.
+func (p Payment) Format(m Money) string { return m.Format(p.Exponent()) }
+
+// money — сумма на проводе: число в основных единицах.
+func money(m Money, exp int) json.RawMessage {
+	return json.RawMessage(m.Format(exp))
+}
+
+// parseMoney разбирает сумму с провода; отсутствующая или null — ноль.
+func parseMoney(field string, raw json.RawMessage, exp int) (Money, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return 0, nil
+	}
+	m, err := ParseMoney(string(raw), exp)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", field, err)
+	}
+	return m, nil
+}
+
+type plainItems Items
+
+// itemWire — товар на проводе: цены в основных единицах валюты заказа.
+type itemWire struct {
+	plainItems
+	Price      json.RawMessage `json:"price"`
+	TotalPrice json.RawMessage `json:"total_price"`
+}
+
+func newItemWire(it Items, exp int) itemWire {
+	return itemWire{plainItems(it), money(it.Price, exp), money(it.TotalPrice, exp)}
+}
+
+func (w itemWire) item(i, exp int) (Items, error) {
+	it := Items(w.plainItems)
+	var err error
+	if it.Price, err = parseMoney(fmt.Sprintf("items[%d].price", i), w.Price, exp); err != nil {
+		return it, err
+	}
+	it.TotalPrice, err = parseMoney(fmt.Sprintf("items[%d].total_price", i), w.TotalPrice, exp)
+	return it, err
+}
+
+// PricedItem — товар отдельно от заказа; в JSON цены в основных единицах
+// валюты с Exp знаками.
+type PricedItem struct {
+	Items
+	Exp int
+}
+
+func (p PricedItem) MarshalJSON() ([]byte, error) {
+	return json.Marshal(newItemWire(p.Items, p.Exp))
+}
+
+// date_created передаётся строкой RFC 3339, пустая строка — дата не указана.
+
+// Цены товаров пишутся в основных единицах валюты платежа.
+
+func (o Order) MarshalJSON() ([]byte, error) {
+	type plain Order
+	created := ""
+	if !o.DateCreated.IsZero() {
+		created = o.DateCreated.Format(time.RFC3339Nano)
+	}
+	var items []itemWire
+	if o.Items != nil {
+		exp := o.Payment.Exponent()
+		items = make([]itemWire, len(o.Items))
+		for i, it := range o.Items {
+			items[i] = newItemWire(it, exp)
+		}
+	}
+	return json.Marshal(struct {
+		plain
+		DateCreated string     `json:"date_created"`
+		Items       []itemWire `json:"items"`
+	}{plain(o), created, items})
+}
+
+func (o *Order) UnmarshalJSON(b []byte) error {
+	type plain Order
+	aux := struct {
+		*plain
+		DateCreated string     `json:"date_created"`
+		Items       []itemWire `json:"items"`
+	}{plain: (*plain)(o)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+	o.Items = nil
+	if aux.Items != nil {
+		exp := o.Payment.Exponent()
+		o.Items = make([]Items, len(aux.Items))
+		for i, w := range aux.Items {
+			it, err := w.item(i, exp)
+			if err != nil {
+				return err
+			}
+			o.Items[i] = it
+		}
+	}
+	o.DateCreated = time.Time{}
+	if aux.DateCreated == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, aux.DateCreated)
+	if err != nil {
+		return fmt.Errorf("date_created: %w", err)
+	}
+	o.DateCreated = t
+	return nil
+}
+
+// payment_dt передаётся unix-временем в секундах, 0 — время не указано.
+
+// Суммы платежа пишутся в основных единицах его валюты.
+
+func (p Payment) MarshalJSON() ([]byte, error) {
+	type plain Payment
+	var dt int64
+	if !p.PaymentDT.IsZero() {
+		dt = p.PaymentDT.Unix()
+	}
+	exp := p.Exponent()
+	return json.Marshal(struct {
+		plain
+		Amount       json.RawMessage `json:"amount"`
+		DeliveryCost json.RawMessage `json:"delivery_cost"`
+		GoodsTotal   json.RawMessage `json:"goods_total"`
+		CustomFee    json.RawMessage `json:"custom_fee"`
+		PaymentDT    int64           `json:"payment_dt"`
+	}{plain(p), money(p.Amount, exp), money(p.DeliveryCost, exp),
+		money(p.GoodsTotal, exp), money(p.CustomFee, exp), dt})
+}
+
+func (p *Payment) UnmarshalJSON(b []byte) error {
+	type plain Payment
+	aux := struct {
+		*plain
+		Amount       json.RawMessage `json:"amount"`
+		DeliveryCost json.RawMessage `json:"delivery_cost"`
+		GoodsTotal   json.RawMessage `json:"goods_total"`
+		CustomFee    json.RawMessage `json:"custom_fee"`
+		PaymentDT    *int64          `json:"payment_dt"`
+	}{plain: (*plain)(p)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+	exp := p.Exponent()
+	var err error
+	for _, f := range []struct {
+		name string
+		raw  json.RawMessage
+		dst  *Money
+	}{
+		{"amount", aux.Amount, &p.Amount},
+		{"delivery_cost", aux.DeliveryCost, &p.DeliveryCost},
+		{"goods_total", aux.GoodsTotal, &p.GoodsTotal},
+		{"custom_fee", aux.CustomFee, &p.CustomFee},
+	} {
+		if *f.dst, err = parseMoney("payment."+f.name, f.raw, exp); err != nil {
+			return err
+		}
+	}
+	p.PaymentDT = time.Time{}
+	if aux.PaymentDT == nil || *aux.PaymentDT == 0 {
+		return nil
+	}
+	if *aux.PaymentDT < 0 {
+		return errors.New("payment_dt: negative unix time")
+	}
+	p.PaymentDT = time.Unix(*aux.PaymentDT, 0).UTC()
+	return nil
+}
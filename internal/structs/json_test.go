@@ -0,0 +1,127 @@
+package structs
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestOrderJSON_ModelRoundTrip(t *testing.T) {
+	raw, err := os.ReadFile("../../data/model.json")
+	if err != nil {
+		t.Fatalf("read model: %v", err)
+	}
+	var o Order
+	if err := json.Unmarshal(raw, &o); err != nil {
+		t.Fatalf("decode model: %v", err)
+	}
+	if !o.DateCreated.Equal(time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC)) {
+		t.Fatalf("bad date_created: %v", o.DateCreated)
+	}
+	if o.Payment.PaymentDT.Unix() != 1637907727 {
+		t.Fatalf("bad payment_dt: %v", o.Payment.PaymentDT)
+	}
+	if o.Payment.Amount != 181700 || o.Items[0].Price != 45300 {
+		t.Fatalf("money must be stored in minor units: %d, %d", o.Payment.Amount, o.Items[0].Price)
+	}
+
+	// формат на проводе не меняется: те же ключи и значения, что в model.json
+	out, err := json.Marshal(o)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	var want, got map[string]any
+	_ = json.Unmarshal(raw, &want)
+	_ = json.Unmarshal(out, &got)
+	wantJSON, _ := json.Marshal(want)
+	gotJSON, _ := json.Marshal(got)
+	if string(wantJSON) != string(gotJSON) {
+		t.Fatalf("wire format changed:\nwant %s\ngot  %s", wantJSON, gotJSON)
+	}
+}
+
+func TestOrderJSON_EmptyDates(t *testing.T) {
+	var o Order
+	if err := json.Unmarshal([]byte(`{"date_created":"","payment":{"payment_dt":0}}`), &o); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !o.DateCreated.IsZero() || !o.Payment.PaymentDT.IsZero() {
+		t.Fatalf("empty dates must decode to zero time: %+v", o)
+	}
+	out, _ := json.Marshal(o)
+	var got struct {
+		DateCreated string `json:"date_created"`
+		Payment     struct {
+			PaymentDT int64 `json:"payment_dt"`
+		} `json:"payment"`
+	}
+	if err := json.Unmarshal(out, &got); err != nil || got.DateCreated != "" || got.Payment.PaymentDT != 0 {
+		t.Fatalf("zero dates must encode as before: %s", out)
+	}
+
+	if err := json.Unmarshal([]byte(`{"date_created":"yesterday"}`), &o); err == nil {
+		t.Fatalf("expected error for bad date_created")
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	cases := []struct {
+		currency string
+		in       string
+		want     Money
+		out      string
+	}{
+		{"USD", "1817", 181700, "1817"},
+		{"USD", "18.17", 1817, "18.17"},
+		{"USD", "18.5", 1850, "18.50"},
+		{"USD", "0.05", 5, "0.05"},
+		{"USD", "-3.2", -320, "-3.20"},
+		{"USD", "0", 0, "0"},
+		{"JPY", "1817", 1817, "1817"},
+		{"JPY", "1817.00", 1817, "1817"},
+		{"BHD", "1.234", 1234, "1.234"},
+		{"BHD", "1.2", 1200, "1.200"},
+		{"CLF", "0.0001", 1, "0.0001"},
+	}
+	for _, c := range cases {
+		var p Payment
+		in := `{"currency":"` + c.currency + `","amount":` + c.in + `}`
+		if err := json.Unmarshal([]byte(in), &p); err != nil {
+			t.Fatalf("%s %s: %v", c.currency, c.in, err)
+		}
+		if p.Amount != c.want {
+			t.Fatalf("%s %s: got %d, want %d", c.currency, c.in, p.Amount, c.want)
+		}
+		out, _ := json.Marshal(p)
+		var got struct {
+			Amount json.RawMessage `json:"amount"`
+		}
+		if err := json.Unmarshal(out, &got); err != nil || string(got.Amount) != c.out {
+			t.Fatalf("%s %s: encoded as %s, want %s", c.currency, c.in, got.Amount, c.out)
+		}
+	}
+
+	for _, c := range []struct{ currency, in string }{
+		{"USD", "1.005"}, {"USD", "1e3"}, {"USD", `"10"`}, {"USD", "-"}, {"USD", ".5"},
+		{"JPY", "1.5"}, {"BHD", "1.2345"},
+	} {
+		var p Payment
+		in := `{"currency":"` + c.currency + `","amount":` + c.in + `}`
+		if err := json.Unmarshal([]byte(in), &p); err == nil {
+			t.Fatalf("%s %s: expected error", c.currency, c.in)
+		}
+	}
+
+	// цены товаров — в валюте платежа заказа
+	var o Order
+	if err := json.Unmarshal([]byte(`{"payment":{"currency":"JPY"},"items":[{"price":453,"total_price":317}]}`), &o); err != nil {
+		t.Fatal(err)
+	}
+	if o.Items[0].Price != 453 || o.Items[0].TotalPrice != 317 {
+		t.Fatalf("JPY item prices: %+v", o.Items[0])
+	}
+	if err := json.Unmarshal([]byte(`{"payment":{"currency":"JPY"},"items":[{"price":4.5}]}`), &o); err == nil {
+		t.Fatal("expected error for a fractional JPY price")
+	}
+}
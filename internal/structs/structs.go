@@ -1,52 +1,54 @@
 package structs
 
-type Order struct{
-	OrderUID string `json:"order_uid"`
-	TrackNumber string `json:"track_number"`
-	Entry string `json:"entry"`
-	Localization string `json:"locale"`
-	InternalSignature string `json:"internal_signature"`
-	CustomerID string `json:"customer_id"`
-	DeliveryService string `json:"delivery_service"`
-	ShardKey string `json:"shardkey"`
-	StorageID int `json:"sm_id"`
-	DateCreated string `json:"date_created"`
-	OofShard string `json:"oof_shard"`
-	Delivery Delivery `json:"delivery"`
-	Payment Payment `json:"payment"`
-	Items []Items `json:"items"`
+import "time"
+
+type Order struct {
+	OrderUID          string    `json:"order_uid"`
+	TrackNumber       string    `json:"track_number"`
+	Entry             string    `json:"entry"`
+	Localization      string    `json:"locale"`
+	InternalSignature string    `json:"internal_signature"`
+	CustomerID        string    `json:"customer_id"`
+	DeliveryService   string    `json:"delivery_service"`
+	ShardKey          string    `json:"shardkey"`
+	StorageID         int       `json:"sm_id"`
+	DateCreated       time.Time `json:"date_created"`
+	OofShard          string    `json:"oof_shard"`
+	Delivery          Delivery  `json:"delivery"`
+	Payment           Payment   `json:"payment"`
+	Items             []Items   `json:"items"`
 }
-type Delivery struct{
-	Name string `json:"name"`
-	Phone string `json:"phone"`
-	ZIP string `json:"zip"`
-	City string `json:"city"`
+type Delivery struct {
+	Name    string `json:"name"`
+	Phone   string `json:"phone"`
+	ZIP     string `json:"zip"`
+	City    string `json:"city"`
 	Address string `json:"address"`
-	Region string `json:"region"`
-	Email string `json:"email"`
+	Region  string `json:"region"`
+	Email   string `json:"email"`
+}
+type Payment struct {
+	Transaction  string    `json:"transaction"`
+	RequestID    string    `json:"request_id"`
+	Currency     string    `json:"currency"`
+	Provider     string    `json:"provider"`
+	Amount       Money     `json:"amount"`
+	PaymentDT    time.Time `json:"payment_dt"`
+	Bank         string    `json:"bank"`
+	DeliveryCost Money     `json:"delivery_cost"`
+	GoodsTotal   Money     `json:"goods_total"`
+	CustomFee    Money     `json:"custom_fee"`
 }
-type Payment struct{
-	Transaction string `json:"transaction"`
-	RequestID string `json:"request_id"`
-	Currency string `json:"currency"`
-	Provider string `json:"provider"`
-	Amount int `json:"amount"`
-	PaymentDT int64 `json:"payment_dt"`
-	Bank string `json:"bank"`
-	DeliveryCost int `json:"delivery_cost"`
-	GoodsTotal int `json:"goods_total"`
-	CustomFee int `json:"custom_fee"`
+type Items struct {
+	ChartID        int64  `json:"chrt_id"`
+	TrackNumber    string `json:"track_number"`
+	Price          Money  `json:"price"`
+	Rid            string `json:"rid"`
+	Name           string `json:"name"`
+	Sale           int    `json:"sale"`
+	Size           string `json:"size"`
+	TotalPrice     Money  `json:"total_price"`
+	NomenclatureID int64  `json:"nm_id"`
+	Brand          string `json:"brand"`
+	Status         int    `json:"status"`
 }
-type Items struct{
-	ChartID int64 `json:"chrt_id"`
-	TrackNumber string `json:"track_number"`
-	Price int `json:"price"`
-	Rid string `json:"rid"`
-	Name string `json:"name"`
-	Sale int `json:"sale"`
-	Size string `json:"size"`
-	TotalPrice int64 `json:"total_price"`
-	NomenclatureID int64 `json:"nm_id"`
-	Brand string `json:"brand"`
-	Status int `json:"status"`
-}
\ No newline at end of file
@@ -21,6 +21,9 @@ func ValidateOrder(o *structs.Order) error {
 	if o.Payment.Amount < 0 || o.Payment.DeliveryCost < 0 || o.Payment.GoodsTotal < 0 {
 		return errors.New("invalid value: negative values")
 	}
+	if !isCurrency(o.Payment.Currency) {
+		return errors.New("invalid value: currency must be a known ISO 4217 code")
+	}
 	if len(o.Items) == 0 {
 		return errors.New("invalid value: no items in list")
 	}
@@ -34,3 +37,10 @@ func ValidateOrder(o *structs.Order) error {
 	}
 	return nil
 }
+
+// isCurrency — действующий код валюты ISO 4217: у неизвестного кода нет
+// числа знаков после запятой, по которому переводятся суммы.
+func isCurrency(s string) bool {
+	_, ok := structs.CurrencyExponent(s)
+	return ok
+}
@@ -13,7 +13,7 @@ func TestValidateOrder_OK(t *testing.T) {
 			Email: "a@b.c", Phone: "123",
 		},
 		Payment: structs.Payment{
-			Amount: 100, DeliveryCost: 10, GoodsTotal: 90,
+			Currency: "USD", Amount: 100, DeliveryCost: 10, GoodsTotal: 90,
 		},
 		Items: []structs.Items{
 			{Name: "item", Price: 10, TotalPrice: 10},
@@ -28,7 +28,7 @@ func TestValidateOrder_EmptyUID(t *testing.T) {
 	o := &structs.Order{
 		OrderUID: "",
 		Delivery: structs.Delivery{Email: "a@b.c", Phone: "1"},
-		Payment:  structs.Payment{Amount: 1},
+		Payment:  structs.Payment{Currency: "USD", Amount: 1},
 		Items:    []structs.Items{{Name: "x", Price: 1, TotalPrice: 1}},
 	}
 	if err := ValidateOrder(o); err == nil {
@@ -40,7 +40,7 @@ func TestValidateOrder_NoContacts(t *testing.T) {
 	o := &structs.Order{
 		OrderUID: "x",
 		Delivery: structs.Delivery{Email: "", Phone: ""},
-		Payment:  structs.Payment{Amount: 1},
+		Payment:  structs.Payment{Currency: "USD", Amount: 1},
 		Items:    []structs.Items{{Name: "x", Price: 1, TotalPrice: 1}},
 	}
 	if err := ValidateOrder(o); err == nil {
@@ -52,7 +52,7 @@ func TestValidateOrder_NegativeNumbers(t *testing.T) {
 	o := &structs.Order{
 		OrderUID: "x",
 		Delivery: structs.Delivery{Email: "a@b.c", Phone: "1"},
-		Payment:  structs.Payment{Amount: -1, DeliveryCost: 0, GoodsTotal: 0},
+		Payment:  structs.Payment{Currency: "USD", Amount: -1, DeliveryCost: 0, GoodsTotal: 0},
 		Items:    []structs.Items{{Name: "x", Price: 1, TotalPrice: 1}},
 	}
 	if err := ValidateOrder(o); err == nil {
@@ -64,7 +64,7 @@ func TestValidateOrder_EmptyItems(t *testing.T) {
 	o := &structs.Order{
 		OrderUID: "x",
 		Delivery: structs.Delivery{Email: "a@b.c", Phone: "1"},
-		Payment:  structs.Payment{Amount: 1, DeliveryCost: 0, GoodsTotal: 1},
+		Payment:  structs.Payment{Currency: "USD", Amount: 1, DeliveryCost: 0, GoodsTotal: 1},
 		Items:    []structs.Items{},
 	}
 	if err := ValidateOrder(o); err == nil {
@@ -76,10 +76,24 @@ func TestValidateOrder_ItemHasEmptyName(t *testing.T) {
 	o := &structs.Order{
 		OrderUID: "x",
 		Delivery: structs.Delivery{Email: "a@b.c", Phone: "1"},
-		Payment:  structs.Payment{Amount: 1, DeliveryCost: 0, GoodsTotal: 1},
+		Payment:  structs.Payment{Currency: "USD", Amount: 1, DeliveryCost: 0, GoodsTotal: 1},
 		Items:    []structs.Items{{Name: "", Price: 1, TotalPrice: 1}},
 	}
 	if err := ValidateOrder(o); err == nil {
 		t.Fatalf("expected error for empty item name")
 	}
 }
+
+func TestValidateOrder_BadCurrency(t *testing.T) {
+	for _, currency := range []string{"", "usd", "RUBL", "ABC", "XAU"} {
+		o := &structs.Order{
+			OrderUID: "x",
+			Delivery: structs.Delivery{Email: "a@b.c", Phone: "1"},
+			Payment:  structs.Payment{Currency: currency, Amount: 1, GoodsTotal: 1},
+			Items:    []structs.Items{{Name: "x", Price: 1, TotalPrice: 1}},
+		}
+		if err := ValidateOrder(o); err == nil {
+			t.Fatalf("expected error for currency %q", currency)
+		}
+	}
+}